@@ -21,6 +21,7 @@ type AyameConn struct {
 	candidates []*ICECandidate
 
 	sendLock sync.Mutex
+	errLock  sync.Mutex
 }
 
 type JsonSocket interface {
@@ -77,7 +78,7 @@ func (c *AyameConn) recvLoop(msgCh chan<- *SignalingMessage) {
 		var msg SignalingMessage
 		err := c.soc.ReadJSON(&msg)
 		if err != nil {
-			c.LastError = err
+			c.setError(err)
 			return
 		}
 		select {
@@ -87,9 +88,11 @@ func (c *AyameConn) recvLoop(msgCh chan<- *SignalingMessage) {
 		}
 		switch msg.Type {
 		case "ping":
+			c.sendLock.Lock()
 			err := c.soc.WriteJSON(&EmptyMessage{Type: "pong"})
+			c.sendLock.Unlock()
 			if err != nil {
-				c.LastError = err
+				c.setError(err)
 				return
 			}
 		case "pong":
@@ -105,14 +108,30 @@ func (c *AyameConn) recvLoop(msgCh chan<- *SignalingMessage) {
 			log.Println("unknown message type:", msg.Type)
 		}
 		if msg.Type == "answer" || msg.Type == "offer" {
+			c.sendLock.Lock()
 			c.ready.Store(true)
 			for _, cand := range c.candidates {
-				c.send(&SignalingMessage{Type: "candidate", ICE: cand})
+				c.soc.WriteJSON(&SignalingMessage{Type: "candidate", ICE: cand})
 			}
+			c.candidates = nil
+			c.sendLock.Unlock()
 		}
 	}
 }
 
+func (c *AyameConn) setError(err error) {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+	c.LastError = err
+}
+
+// Err returns the last error.
+func (c *AyameConn) Err() error {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+	return c.LastError
+}
+
 func (c *AyameConn) send(msg *SignalingMessage) error {
 	c.sendLock.Lock()
 	defer c.sendLock.Unlock()
@@ -129,11 +148,13 @@ func (c *AyameConn) Offer(sdp string) error {
 
 func (c *AyameConn) Candidate(candidate string, id *string, index *uint16) error {
 	cand := &ICECandidate{Candidate: candidate, SdpMid: id, SdpMLineIndex: index}
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
 	if !c.ready.Load() {
 		c.candidates = append(c.candidates, cand)
 		return nil
 	}
-	return c.send(&SignalingMessage{Type: "candidate", ICE: cand})
+	return c.soc.WriteJSON(&SignalingMessage{Type: "candidate", ICE: cand})
 }
 
 func (c *AyameConn) Close() {
@@ -0,0 +1,178 @@
+package ayame
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Server is a minimal Ayame compatible signaling server.
+type Server struct {
+	SignalingKey string
+	IceServers   []*IceServer
+	PingInterval time.Duration
+
+	upgrader websocket.Upgrader
+	lock     sync.Mutex
+	rooms    map[string][]*serverConn
+}
+
+type serverConn struct {
+	ws       *websocket.Conn
+	roomID   string
+	sendLock sync.Mutex
+}
+
+func NewServer(signalingKey string) *Server {
+	return &Server{
+		SignalingKey: signalingKey,
+		PingInterval: 10 * time.Second,
+		upgrader:     websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		rooms:        map[string][]*serverConn{},
+	}
+}
+
+func (c *serverConn) send(msgType int, data []byte) error {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	return c.ws.WriteMessage(msgType, data)
+}
+
+func (c *serverConn) sendJSON(v any) error {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	return c.ws.WriteJSON(v)
+}
+
+func (s *Server) join(conn *serverConn) (bool, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	clients := s.rooms[conn.roomID]
+	if len(clients) >= 2 {
+		return false, false
+	}
+	s.rooms[conn.roomID] = append(clients, conn)
+	return true, len(clients) > 0
+}
+
+func (s *Server) leave(conn *serverConn) *serverConn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var other *serverConn
+	var clients []*serverConn
+	for _, c := range s.rooms[conn.roomID] {
+		if c != conn {
+			clients = append(clients, c)
+			other = c
+		}
+	}
+	if len(clients) == 0 {
+		delete(s.rooms, conn.roomID)
+	} else {
+		s.rooms[conn.roomID] = clients
+	}
+	return other
+}
+
+func (s *Server) peer(conn *serverConn) *serverConn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.rooms[conn.roomID] {
+		if c != conn {
+			return c
+		}
+	}
+	return nil
+}
+
+// Clients returns the number of clients in the room.
+func (s *Server) Clients(roomID string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.rooms[roomID])
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("upgrade error:", err)
+		return
+	}
+	defer ws.Close()
+
+	timeout := s.PingInterval * 3
+	ws.SetReadDeadline(time.Now().Add(timeout))
+	var register RegisterMessage
+	if err := ws.ReadJSON(&register); err != nil {
+		return
+	}
+	if register.Type != "register" || register.RoomID == "" {
+		ws.WriteJSON(&AuthResultMessage{Type: "reject", Reason: "invalid register message"})
+		return
+	}
+	if s.SignalingKey != "" && subtle.ConstantTimeCompare([]byte(register.SignalingKey), []byte(s.SignalingKey)) != 1 {
+		ws.WriteJSON(&AuthResultMessage{Type: "reject", Reason: "invalid signaling key"})
+		return
+	}
+
+	conn := &serverConn{ws: ws, roomID: register.RoomID}
+	ok, exist := s.join(conn)
+	if !ok {
+		ws.WriteJSON(&AuthResultMessage{Type: "reject", Reason: "full"})
+		return
+	}
+	defer func() {
+		if other := s.leave(conn); other != nil {
+			other.sendJSON(&EmptyMessage{Type: "bye"})
+		}
+	}()
+	err = conn.sendJSON(&AuthResultMessage{Type: "accept", IsExistClient: exist, IceServers: s.IceServers})
+	if err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(s.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if conn.sendJSON(&EmptyMessage{Type: "ping"}) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(timeout))
+		msgType, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg EmptyMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case "pong":
+		case "bye":
+			return
+		case "offer", "answer", "candidate":
+			if other := s.peer(conn); other != nil {
+				other.send(msgType, data)
+			}
+		default:
+			log.Println("unknown message type:", msg.Type)
+		}
+	}
+}
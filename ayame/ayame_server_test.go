@@ -0,0 +1,73 @@
+package ayame
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	server := NewServer("key")
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http")
+
+	_, err := Dial(url, "room1", "invalid")
+	if err == nil {
+		t.Fatal("Dial() should be failed with invalid key")
+	}
+
+	conn1, err := Dial(url, "room1", "key")
+	if err != nil {
+		t.Fatal("Dial() error: ", err)
+	}
+	defer conn1.Close()
+	if conn1.AuthResult.IsExistClient {
+		t.Error("IsExistClient should be false")
+	}
+
+	conn2, err := Dial(url, "room1", "key")
+	if err != nil {
+		t.Fatal("Dial() error: ", err)
+	}
+	defer conn2.Close()
+	if !conn2.AuthResult.IsExistClient {
+		t.Error("IsExistClient should be true")
+	}
+	if n := server.Clients("room1"); n != 2 {
+		t.Error("Clients() error: ", n)
+	}
+
+	_, err = Dial(url, "room1", "key")
+	if err == nil {
+		t.Fatal("Dial() should be failed when the room is full")
+	}
+
+	conn2.Offer("offer-sdp")
+	select {
+	case msg := <-conn1.Msg:
+		if msg.Type != "offer" || msg.SDP != "offer-sdp" {
+			t.Error("unexpected message: ", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("offer timeout")
+	}
+
+	conn1.Answer("answer-sdp")
+	select {
+	case msg := <-conn2.Msg:
+		if msg.Type != "answer" || msg.SDP != "answer-sdp" {
+			t.Error("unexpected message: ", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("answer timeout")
+	}
+
+	conn2.Close()
+	select {
+	case <-conn1.Done():
+	case <-time.After(time.Second):
+		t.Fatal("bye timeout")
+	}
+}
@@ -19,6 +19,10 @@ type Config struct {
 
 	RoomName  string
 	AuthToken string
+	User      string
+
+	RemoteFingerprint  string
+	MinProtocolVersion int
 }
 
 func DefaultConfig() *Config {
@@ -45,6 +49,8 @@ func main() {
 	confPath := flag.String("conf", "config.toml", "conf path")
 	roomName := flag.String("room", "", "Ayame room name")
 	authToken := flag.String("passwd", "", "Connect password")
+	user := flag.String("user", "", "User name")
+	remoteFingerprint := flag.String("fingerprint", "", "Expected remote certificate fingerprint")
 	flag.Parse()
 
 	config := loadConfig(*confPath)
@@ -54,6 +60,12 @@ func main() {
 	if *authToken != "" {
 		config.AuthToken = *authToken
 	}
+	if *user != "" {
+		config.User = *user
+	}
+	if *remoteFingerprint != "" {
+		config.RemoteFingerprint = *remoteFingerprint
+	}
 
 	mountpoint := "X:"
 	if flag.Arg(0) != "" {
@@ -65,13 +77,18 @@ func main() {
 		SignalingKey: config.SignalingKey,
 		RoomID:       config.RoomIdPrefix + config.RoomName,
 		Password:     config.AuthToken,
+		User:         config.User,
+
+		RemoteFingerprint:  config.RemoteFingerprint,
+		MinProtocolVersion: config.MinProtocolVersion,
 	}
 
-	rtcConn, client, err := rtcfs.GetClinet(context.Background(), options, &rtcfs.ClientOptions{MaxRedirect: 3})
+	client, err := rtcfs.GetReconnectingClient(context.Background(), options, &rtcfs.ClientOptions{MaxRedirect: 3})
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer rtcConn.Close()
+	defer client.Close()
+	client.ExtendedStat = true
 
 	m, _ := fsmount.MountFS(mountpoint, client, nil)
 	defer m.Close()
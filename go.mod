@@ -12,6 +12,7 @@ require (
 	github.com/pion/webrtc/v3 v3.1.43
 	golang.org/x/image v0.3.0
 	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.5.0
 )
 
 require (
@@ -35,5 +36,4 @@ require (
 	github.com/pion/udp v0.1.1 // indirect
 	golang.org/x/crypto v0.0.0-20220516162934-403b01795ae8 // indirect
 	golang.org/x/net v0.0.0-20220722155237-a158d28d115b // indirect
-	golang.org/x/sys v0.5.0 // indirect
 )
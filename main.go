@@ -7,11 +7,14 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/binzume/cfs/zipfs"
+	"github.com/binzume/webrtcfs/ayame"
 	"github.com/binzume/webrtcfs/rtcfs"
 	"github.com/binzume/webrtcfs/socfs"
 )
@@ -25,13 +28,37 @@ type Config struct {
 
 	Name      string
 	Password  string
+	User      string
 	LocalPath string
 
-	Writable bool
-	Unzip    bool
+	Users []*rtcfs.User
+
+	PeerStorePath string
+
+	CertificatePath   string
+	RemoteFingerprint string
+
+	MinProtocolVersion   int
+	DisablePlaintextAuth bool
+	AuthTimeoutSec       int
+	MaxAuthFailures      int
+	AuthRateLimit        int
+
+	Writable         bool
+	Unzip            bool
+	RestrictSymlinks bool
+
+	MaxPeers             int
+	MaxPendingPeers      int
+	ConnectTimeoutSec    int
+	IdleTimeoutSec       int
+	StatusLogIntervalSec int
 
 	ThumbnailCacheDir string
 	FFmpegPath        string
+
+	SignalingServerAddr string
+	SignalingServerKey  string
 }
 
 func DefaultConfig() *Config {
@@ -41,8 +68,18 @@ func DefaultConfig() *Config {
 	config.RoomIdPrefix = "binzume@rdp-room-"
 	config.PairingRoomIdPrefix = "binzume@rdp-pin-"
 	config.PairingTimeoutSec = 600
+	config.PeerStorePath = "peers.json"
+	config.MaxPeers = 8
+	config.MaxPendingPeers = 4
+	config.ConnectTimeoutSec = 60
+	config.IdleTimeoutSec = 3600
+	config.StatusLogIntervalSec = 600
+	config.AuthTimeoutSec = 30
+	config.MaxAuthFailures = 3
+	config.AuthRateLimit = 10
 	config.ThumbnailCacheDir = "cache"
 	config.FFmpegPath = os.Getenv("FFMPEG_PATH")
+	config.SignalingServerAddr = ":3000"
 	return &config
 }
 
@@ -65,7 +102,11 @@ func publishFiles(ctx context.Context, config *Config, options *rtcfs.ConnectOpt
 			socfs.DefaultThumbnailer.Register(socfs.NewVideoThumbnailer(config.ThumbnailCacheDir, config.FFmpegPath))
 		}
 	}
-	var fsys fs.FS = socfs.NewWritableDirFS(config.LocalPath)
+	dirfs := socfs.NewWritableDirFS(config.LocalPath)
+	if config.RestrictSymlinks {
+		dirfs.RestrictSymlinks()
+	}
+	var fsys fs.FS = dirfs
 	if config.Unzip {
 		fsys = zipfs.NewAutoUnzipFS(fsys)
 		socfs.ContentTypes[".zip"] = "application/zip;x-traversable"
@@ -76,11 +117,25 @@ func publishFiles(ctx context.Context, config *Config, options *rtcfs.ConnectOpt
 		wfsys.ReadOnly()
 	}
 	log.Println("connecting... ", options.RoomID)
-	return rtcfs.StartRedirector(ctx, options, func(roomID string) {
-		// TODO: connect timeout
-		log.Println("temporary room:", roomID)
-		rtcfs.PublishRoomID(ctx, options, roomID, wfsys)
-	})
+	publisher := rtcfs.NewPublisher(options, &rtcfs.PublisherOptions{
+		MaxPeers:        config.MaxPeers,
+		MaxPendingPeers: config.MaxPendingPeers,
+		ConnectTimeout:  time.Duration(config.ConnectTimeoutSec) * time.Second,
+		IdleTimeout:     time.Duration(config.IdleTimeoutSec) * time.Second,
+		StatusInterval:  time.Duration(config.StatusLogIntervalSec) * time.Second,
+	}, wfsys)
+	return publisher.Start(ctx)
+}
+
+func startSignalingServer(config *Config) error {
+	mux := http.NewServeMux()
+	mux.Handle("/signaling", ayame.NewServer(config.SignalingServerKey))
+	addr := config.SignalingServerAddr
+	if host, port, err := net.SplitHostPort(addr); err == nil && (host == "" || net.ParseIP(host).IsUnspecified()) {
+		addr = net.JoinHostPort("localhost", port)
+	}
+	log.Println("signaling server: ws://" + addr + "/signaling")
+	return http.ListenAndServe(config.SignalingServerAddr, mux)
 }
 
 func main() {
@@ -88,8 +143,10 @@ func main() {
 	name := flag.String("room", "", "Room name")
 	displayName := flag.String("name", "rtcfs", "Display name(pairing)")
 	password := flag.String("passwd", "", "Connect password")
+	user := flag.String("user", "", "User name")
 	signalingUrl := flag.String("signalingUrl", "", "Ayame signaling url")
 	signalingKey := flag.String("signalingKey", "", "Ayame signaling key")
+	remoteFingerprint := flag.String("fingerprint", "", "Expected remote certificate fingerprint")
 	writable := flag.Bool("writable", false, "writable fs")
 	unzip := flag.Bool("unzip", false, "Allow ReadDir() for .zip file(experiment)")
 	flag.Parse()
@@ -101,12 +158,18 @@ func main() {
 	if *password != "" {
 		config.Password = *password
 	}
+	if *user != "" {
+		config.User = *user
+	}
 	if *signalingUrl != "" {
 		config.SignalingUrl = *signalingUrl
 	}
 	if *signalingKey != "" {
 		config.SignalingKey = *signalingKey
 	}
+	if *remoteFingerprint != "" {
+		config.RemoteFingerprint = *remoteFingerprint
+	}
 	if *writable {
 		config.Writable = *writable
 	}
@@ -119,6 +182,30 @@ func main() {
 		SignalingKey: config.SignalingKey,
 		RoomID:       config.RoomIdPrefix + config.Name,
 		Password:     config.Password,
+		User:         config.User,
+		Users:        config.Users,
+
+		RemoteFingerprint: config.RemoteFingerprint,
+
+		MinProtocolVersion:   config.MinProtocolVersion,
+		DisablePlaintextAuth: config.DisablePlaintextAuth,
+		AuthTimeout:          time.Duration(config.AuthTimeoutSec) * time.Second,
+		MaxAuthFailures:      config.MaxAuthFailures,
+		AuthRateLimit:        config.AuthRateLimit,
+	}
+	if config.CertificatePath != "" {
+		cert, err := rtcfs.LoadOrCreateCertificate(config.CertificatePath)
+		if err != nil {
+			log.Fatal("Failed to load ", config.CertificatePath, err)
+		}
+		options.Certificate = cert
+	}
+	if config.PeerStorePath != "" {
+		peerStore, err := rtcfs.LoadPeerStore(config.PeerStorePath)
+		if err != nil {
+			log.Fatal("Failed to load ", config.PeerStorePath, err)
+		}
+		options.PeerStore = peerStore
 	}
 
 	switch flag.Arg(0) {
@@ -133,13 +220,38 @@ func main() {
 		if err != nil {
 			log.Println(err)
 		}
+	case "peers":
+		if options.PeerStore == nil {
+			log.Fatal("PeerStorePath is not configured")
+		}
+		for _, peer := range options.PeerStore.List() {
+			fmt.Println(peer.Name, "\t", peer.PairedAt.Format(time.RFC3339), "\t", peer.Fingerprint)
+		}
+	case "unpair":
+		if options.PeerStore == nil {
+			log.Fatal("PeerStorePath is not configured")
+		}
+		n, err := options.PeerStore.Remove(flag.Arg(1))
+		if err != nil {
+			log.Println(err)
+		}
+		log.Println("removed:", n)
+	case "fingerprint":
+		if options.Certificate == nil {
+			log.Fatal("CertificatePath is not configured")
+		}
+		fingerprint, err := rtcfs.CertificateFingerprint(options.Certificate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(fingerprint)
 	case "shell":
 		err := rtcfs.StartShell(context.Background(), options)
 		if err != nil {
 			log.Println(err)
 		}
-	case "pull", "push", "ls", "cat", "rm", "mkdir":
-		err := rtcfs.ShellExec(context.Background(), options, flag.Arg(0), flag.Arg(1))
+	case "pull", "push", "sync", "ls", "cat", "rm", "mkdir", "cp", "mv":
+		err := rtcfs.ShellExecArgs(context.Background(), options, flag.Arg(0), flag.Args()[1:])
 		if err != nil {
 			log.Println(err)
 		}
@@ -154,6 +266,14 @@ func main() {
 			}
 			time.Sleep(5 * time.Second)
 		}
+	case "signaling":
+		if flag.Arg(1) != "" {
+			config.SignalingServerAddr = flag.Arg(1)
+		}
+		err := startSignalingServer(config)
+		if err != nil {
+			log.Println(err)
+		}
 	default:
 		fmt.Println("Unknown sub command: ", flag.Arg(0))
 		flag.Usage()
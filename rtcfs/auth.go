@@ -0,0 +1,195 @@
+package rtcfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/binzume/webrtcfs/socfs"
+)
+
+// Auth protocol on the controlEvent channel:
+//
+//	v1: client: {type: "auth", fingerprint, hmac: HMAC(password, fingerprint)} or {type: "auth", token: password}
+//	v2: server: {type: "challenge", nonce, version: 2}
+//	    client: {type: "auth", version: 2, fingerprint, hmac: HMAC(password, nonce + client fingerprint + server fingerprint)}
+const AuthProtocolVersion = 2
+
+// Clients fall back to v1 if no challenge is received.
+var authChallengeTimeout = 2 * time.Second
+
+type authRateLimiter struct {
+	lock     sync.Mutex
+	attempts map[string][]time.Time
+}
+
+var authLimiter = &authRateLimiter{attempts: map[string][]time.Time{}}
+
+// Attempts for the room are limited to AuthRateLimit * authRoomLimitFactor.
+const authRoomLimitFactor = 10
+
+// Attempts are rejected if the limiter has too many keys.
+var authLimiterMaxKeys = 10000
+
+type authLimit struct {
+	key   string
+	limit int
+}
+
+// allow returns false if more than limit attempts were made in the last minute.
+func (l *authRateLimiter) allow(key string, limit int, now time.Time) bool {
+	return limit <= 0 || l.allowAll(now, authLimit{key, limit})
+}
+
+// allowPeer limits attempts per remote peer and per room. The room limit is checked first.
+func (l *authRateLimiter) allowPeer(room, peer string, limit int, now time.Time) bool {
+	return limit <= 0 || l.allowAll(now, authLimit{room, limit * authRoomLimitFactor}, authLimit{room + "\n" + peer, limit})
+}
+
+// allowAll records the attempt only if all limits are satisfied.
+func (l *authRateLimiter) allowAll(now time.Time, limits ...authLimit) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for key := range l.attempts {
+		l.recent(key, now)
+	}
+	for _, lim := range limits {
+		if len(l.recent(lim.key, now)) >= lim.limit {
+			return false
+		}
+	}
+	if len(l.attempts)+len(limits) > authLimiterMaxKeys {
+		return false
+	}
+	for _, lim := range limits {
+		l.attempts[lim.key] = append(l.attempts[lim.key], now)
+	}
+	return true
+}
+
+// recent returns attempts in the last minute. Keys without recent attempts are removed.
+func (l *authRateLimiter) recent(key string, now time.Time) []time.Time {
+	var recent []time.Time
+	for _, t := range l.attempts[key] {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) == 0 {
+		delete(l.attempts, key)
+	} else {
+		l.attempts[key] = recent
+	}
+	return recent
+}
+
+type authRequest struct {
+	Type       string `json:"type"`
+	Version    int    `json:"version"`
+	User       string `json:"user"`
+	Token      string `json:"token"` // legacy plaintext auth
+	Fingeprint string `json:"fingerprint"`
+	Hmac       []byte `json:"hmac"`
+}
+
+func (a *authRequest) version() int {
+	if a.Version == 0 {
+		return 1
+	}
+	return a.Version
+}
+
+func authMessage(nonce, clientFingerprint, serverFingerprint string) string {
+	return nonce + "\n" + strings.ToLower(clientFingerprint) + "\n" + strings.ToLower(serverFingerprint)
+}
+
+func authHMAC(secret, msg string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
+
+// newAuthRequest returns v2 auth request if nonce is given. Otherwise v1.
+func newAuthRequest(rtcConn *RTCConn, options *ConnectOptions, nonce string) (*authRequest, error) {
+	fingerprint, err := rtcConn.LocalCertificateFingerprint()
+	if err != nil {
+		return nil, err
+	}
+	auth := &authRequest{Type: "auth", User: options.User, Fingeprint: fingerprint}
+	if nonce == "" {
+		if options.MinProtocolVersion >= 2 {
+			return nil, errors.New("auth challenge timeout")
+		}
+		auth.Hmac = authHMAC(options.Password, fingerprint)
+		return auth, nil
+	}
+	remoteHash, err := rtcConn.RemoteCertificateHash("sha-256")
+	if err != nil {
+		return nil, err
+	}
+	auth.Version = AuthProtocolVersion
+	auth.Hmac = authHMAC(options.Password, authMessage(nonce, fingerprint, "sha-256 "+remoteHash))
+	return auth, nil
+}
+
+// authenticate verifies the password of the user or a paired peer. nil ACL means full access.
+func authenticate(options *ConnectOptions, rtcConn *RTCConn, nonce string, auth *authRequest) (*socfs.ACL, error) {
+	if auth.version() < options.MinProtocolVersion || auth.version() > AuthProtocolVersion {
+		return nil, errors.New("unsupported protocol version")
+	}
+	password := options.Password
+	var acl *socfs.ACL
+	if auth.User != "" {
+		user := options.findUser(auth.User)
+		if user == nil {
+			return nil, errors.New("auth error")
+		}
+		password = user.Password
+		acl = &socfs.ACL{Paths: user.Paths, Writable: user.Writable}
+	}
+	var peer *TrustedPeer
+	if len(auth.Hmac) > 0 {
+		if !rtcConn.ValidateRemoteFingerprint(auth.Fingeprint) {
+			// Broken client or MITM
+			log.Println("fingerprint error: ", auth.Fingeprint)
+			return nil, errors.New("fingerprint error")
+		}
+		msg := auth.Fingeprint
+		if auth.version() >= 2 {
+			localFingerprint, err := rtcConn.LocalCertificateFingerprint()
+			if err != nil {
+				return nil, err
+			}
+			msg = authMessage(nonce, auth.Fingeprint, localFingerprint)
+		}
+		if password != "" && hmac.Equal(authHMAC(password, msg), auth.Hmac) {
+			return acl, nil
+		}
+		if auth.User == "" && options.PeerStore != nil {
+			peer = options.PeerStore.FindByHMAC(msg, auth.Hmac)
+		}
+	} else {
+		if options.DisablePlaintextAuth {
+			return nil, errors.New("plaintext auth disabled")
+		}
+		if password != "" && hmac.Equal([]byte(auth.Token), []byte(password)) {
+			return acl, nil
+		}
+		if auth.User == "" && options.PeerStore != nil {
+			peer = options.PeerStore.FindBySecret(auth.Token)
+		}
+	}
+	if peer != nil {
+		if peer.Fingerprint == "" || !rtcConn.ValidateRemoteFingerprint(peer.Fingerprint) {
+			log.Println("trusted peer fingerprint error:", peer.Name)
+			return nil, errors.New("auth error")
+		}
+		log.Println("trusted peer:", peer.Name)
+		return nil, nil
+	}
+	return nil, errors.New("auth error")
+}
@@ -0,0 +1,82 @@
+package rtcfs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate_plaintext(t *testing.T) {
+	options := &ConnectOptions{Password: "test-password"}
+	auth := &authRequest{Type: "auth", Token: "test-password"}
+	if _, err := authenticate(options, nil, "", auth); err != nil {
+		t.Error("authenticate() error: ", err)
+	}
+	if _, err := authenticate(options, nil, "", &authRequest{Type: "auth", Token: "wrong"}); err == nil {
+		t.Error("authenticate() should be error")
+	}
+
+	options.DisablePlaintextAuth = true
+	if _, err := authenticate(options, nil, "", auth); err == nil {
+		t.Error("plaintext auth should be disabled")
+	}
+
+	options.DisablePlaintextAuth = false
+	options.MinProtocolVersion = 2
+	if _, err := authenticate(options, nil, "", auth); err == nil || err.Error() != "unsupported protocol version" {
+		t.Error("v1 should be rejected: ", err)
+	}
+}
+
+func TestAuthRateLimiter(t *testing.T) {
+	limiter := &authRateLimiter{attempts: map[string][]time.Time{}}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !limiter.allow("room", 3, now) {
+			t.Fatal("should be allowed", i)
+		}
+	}
+	if limiter.allow("room", 3, now) {
+		t.Error("should be limited")
+	}
+	if !limiter.allow("room2", 3, now) {
+		t.Error("other room should be allowed")
+	}
+	if !limiter.allow("room", 3, now.Add(time.Minute)) {
+		t.Error("should be allowed after a minute")
+	}
+}
+
+func TestAuthRateLimiter_peer(t *testing.T) {
+	limiter := &authRateLimiter{attempts: map[string][]time.Time{}}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !limiter.allowPeer("room", "peer1", 3, now) {
+			t.Fatal("should be allowed", i)
+		}
+	}
+	if limiter.allowPeer("room", "peer1", 3, now) {
+		t.Error("should be limited")
+	}
+	if !limiter.allowPeer("room", "peer2", 3, now) {
+		t.Error("other peer should be allowed")
+	}
+	for i := 0; i < 30; i++ {
+		limiter.allowPeer("room", fmt.Sprint("other", i), 3, now)
+	}
+	if limiter.allowPeer("room", "peer3", 3, now) {
+		t.Error("should be limited by the room limit")
+	}
+	if _, ok := limiter.attempts["room\npeer3"]; ok {
+		t.Error("rejected attempt should not be recorded")
+	}
+	if !limiter.allowPeer("room", "peer3", 3, now.Add(time.Minute)) || len(limiter.attempts) != 2 {
+		t.Error("expired keys should be removed: ", len(limiter.attempts))
+	}
+
+	authLimiterMaxKeys = 3
+	defer func() { authLimiterMaxKeys = 10000 }()
+	if limiter.allowPeer("room2", "peer4", 3, now.Add(time.Minute)) {
+		t.Error("should be limited by the number of keys")
+	}
+}
@@ -2,12 +2,11 @@ package rtcfs
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/binzume/webrtcfs/socfs"
 	"github.com/pion/webrtc/v3"
@@ -18,15 +17,62 @@ type ClientOptions struct {
 }
 
 func GetClinet(ctx context.Context, options *ConnectOptions, clientOpt *ClientOptions) (*RTCConn, *socfs.FSClient, error) {
-	return getClinetInternal(ctx, options, options.DefaultRoomID(), clientOpt.MaxRedirect)
+	return getClinetInternal(ctx, options, options.DefaultRoomID(), clientOpt.MaxRedirect, nil)
 }
 
-func getClinetInternal(ctx context.Context, options *ConnectOptions, roomID string, redirectCount int) (*RTCConn, *socfs.FSClient, error) {
+// ReconnectingClient reconnects when the connection is lost.
+type ReconnectingClient struct {
+	*socfs.FSClient
+	ctx       context.Context
+	options   *ConnectOptions
+	clientOpt *ClientOptions
+	lock      sync.Mutex
+	rtcConn   *RTCConn
+	closed    bool
+}
+
+func GetReconnectingClient(ctx context.Context, options *ConnectOptions, clientOpt *ClientOptions) (*ReconnectingClient, error) {
+	rtcConn, client, err := GetClinet(ctx, options, clientOpt)
+	if err != nil {
+		return nil, err
+	}
+	c := &ReconnectingClient{FSClient: client, ctx: ctx, options: options, clientOpt: clientOpt, rtcConn: rtcConn}
+	client.Reconnect = c.reconnect
+	return c, nil
+}
+
+func (c *ReconnectingClient) reconnect() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return errors.New("closed")
+	}
+	c.rtcConn.Close()
+	log.Println("reconnecting...")
+	ctx, cancel := context.WithTimeout(c.ctx, c.FSClient.Timeout)
+	defer cancel()
+	rtcConn, _, err := getClinetInternal(ctx, c.options, c.options.DefaultRoomID(), c.clientOpt.MaxRedirect, c.FSClient)
+	if err != nil {
+		return err
+	}
+	c.rtcConn = rtcConn
+	return nil
+}
+
+func (c *ReconnectingClient) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	return c.rtcConn.Close()
+}
+
+func getClinetInternal(ctx context.Context, options *ConnectOptions, roomID string, redirectCount int, client *socfs.FSClient) (*RTCConn, *socfs.FSClient, error) {
 	log.Println("waiting for connect: ", roomID)
-	var client *socfs.FSClient
+	var generation uint32
+	var clientLock sync.Mutex
 	authorized := options.Password == ""
 
-	rtcConn, err := NewRTCConn(options.SignalingURL, roomID, options.SignalingKey)
+	rtcConn, err := newRTCConn(options, roomID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -35,48 +81,86 @@ func getClinetInternal(ctx context.Context, options *ConnectOptions, roomID stri
 	wg.Add(2)
 
 	var redirect string
-	var services map[string]interface{}
+	var reason string
+	var services map[string]json.RawMessage
+	challenge := make(chan string, 1)
 
 	dataChannels := []DataChannelHandler{&DataChannelCallback{
 		Name: "fileServer",
 		OnOpenFunc: func(dc *webrtc.DataChannel) {
-			client = socfs.NewFSClient(func(req *socfs.FileOperationRequest) error {
-				return dc.SendText(string(req.ToBytes()))
-			})
+			sendFunc := func(req *socfs.FileOperationRequest) error {
+				if req.IsJSON() {
+					return dc.SendText(string(req.ToBytes()))
+				}
+				return dc.Send(req.ToBytes())
+			}
+			clientLock.Lock()
+			if client == nil {
+				client = socfs.NewFSClient(sendFunc)
+			} else {
+				generation = client.SetSendFunc(sendFunc)
+			}
+			clientLock.Unlock()
 			wg.Done()
 		},
 		OnMessageFunc: func(d *webrtc.DataChannel, msg webrtc.DataChannelMessage) {
-			if client != nil {
-				client.HandleMessage(msg.Data, msg.IsString)
+			clientLock.Lock()
+			c := client
+			clientLock.Unlock()
+			if c != nil {
+				c.HandleMessage(msg.Data, msg.IsString)
 			}
 		},
 		OnCloseFunc: func(d *webrtc.DataChannel) {
-			client.Abort()
+			clientLock.Lock()
+			c, gen := client, generation
+			clientLock.Unlock()
+			if c != nil {
+				c.AbortConnection(gen)
+			}
 		},
 	}, &DataChannelCallback{
 		Name: "controlEvent",
 		OnOpenFunc: func(d *webrtc.DataChannel) {
-			fingerprint, _ := rtcConn.LocalCertificateFingerprint()
-			h := hmac.New(sha256.New, []byte(options.Password))
-			h.Write([]byte(fingerprint))
-			j, _ := json.Marshal(map[string]interface{}{
-				"type": "auth",
-				// "token":       options.AuthToken, // TODO: Remove this
-				"fingerprint": fingerprint,
-				"hmac":        h.Sum(nil), // base64 string in json
-			})
-			d.SendText(string(j))
+			if options.RemoteFingerprint != "" && !rtcConn.ValidateRemoteFingerprint(options.RemoteFingerprint) {
+				rtcConn.fail(errors.New("remote fingerprint mismatch"))
+				return
+			}
+			go func() {
+				var nonce string
+				select {
+				case nonce = <-challenge:
+				case <-time.After(authChallengeTimeout):
+				case <-rtcConn.ayameConn.Done():
+					return
+				}
+				auth, err := newAuthRequest(rtcConn, options, nonce)
+				if err != nil {
+					rtcConn.fail(err)
+					return
+				}
+				j, _ := json.Marshal(auth)
+				d.SendText(string(j))
+			}()
 		},
 		OnMessageFunc: func(d *webrtc.DataChannel, msg webrtc.DataChannelMessage) {
 			var event struct {
-				Type     string                 `json:"type"`
-				Result   bool                   `json:"result"`
-				RoomID   string                 `json:"roomId"`
-				Services map[string]interface{} `json:"services"`
+				Type     string                     `json:"type"`
+				Result   bool                       `json:"result"`
+				Reason   string                     `json:"reason"`
+				RoomID   string                     `json:"roomId"`
+				Nonce    string                     `json:"nonce"`
+				Services map[string]json.RawMessage `json:"services"`
 			}
 			_ = json.Unmarshal(msg.Data, &event)
-			if event.Type == "authResult" {
+			if event.Type == "challenge" {
+				select {
+				case challenge <- event.Nonce:
+				default:
+				}
+			} else if event.Type == "authResult" {
 				authorized = event.Result
+				reason = event.Reason
 				services = event.Services
 				wg.Done()
 			} else if event.Type == "redirect" {
@@ -89,7 +173,23 @@ func getClinetInternal(ctx context.Context, options *ConnectOptions, roomID stri
 	rtcConn.Start(dataChannels)
 
 	log.Println("connectiong...")
-	wg.Wait()
+	connected := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(connected)
+	}()
+	select {
+	case <-connected:
+	case <-rtcConn.ayameConn.Done():
+		rtcConn.Close()
+		if err := rtcConn.Wait(ctx); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errors.New("connection closed")
+	case <-ctx.Done():
+		rtcConn.Close()
+		return nil, nil, ctx.Err()
+	}
 
 	if redirect != "" {
 		rtcConn.Close()
@@ -97,7 +197,7 @@ func getClinetInternal(ctx context.Context, options *ConnectOptions, roomID stri
 		if redirectCount <= 0 {
 			return nil, nil, errors.New("too may redirect")
 		}
-		return getClinetInternal(ctx, options, redirect, redirectCount-1)
+		return getClinetInternal(ctx, options, redirect, redirectCount-1, client)
 	}
 
 	log.Println("connected! ", authorized)
@@ -109,7 +209,15 @@ func getClinetInternal(ctx context.Context, options *ConnectOptions, roomID stri
 
 	if !authorized {
 		rtcConn.Close()
+		if reason != "" {
+			return nil, nil, errors.New("auth error: " + reason)
+		}
 		return nil, nil, errors.New("auth error")
 	}
+	if services != nil {
+		var caps socfs.FSCapability
+		_ = json.Unmarshal(services["file"], &caps)
+		client.SetCapability(&caps)
+	}
 	return rtcConn, client, nil
 }
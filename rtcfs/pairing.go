@@ -28,10 +28,14 @@ func Pairing(ctx context.Context, options *PairingOptions) error {
 	ctx, done := context.WithTimeout(ctx, options.Timeout)
 	defer done()
 
+	if options.PeerStore == nil {
+		return errors.New("pairing requires PeerStore")
+	}
+
 	pinstr := fmt.Sprintf("%06d", pin)
 	log.Println("PIN: ", pinstr)
 
-	rtcConn, err := NewRTCConn(options.SignalingURL, options.PairingRoomIDPrefix+pinstr, options.SignalingKey)
+	rtcConn, err := newRTCConn(&options.ConnectOptions, options.PairingRoomIDPrefix+pinstr)
 	if err != nil {
 		return err
 	}
@@ -40,6 +44,10 @@ func Pairing(ctx context.Context, options *PairingOptions) error {
 		return errors.New("room already used")
 	}
 
+	// per-peer secret. master password is never sent.
+	token := randomStr(32)
+
+	var saveErr error
 	dataChannels := []DataChannelHandler{&DataChannelCallback{
 		Name: "secretExchange",
 		OnOpenFunc: func(dc *webrtc.DataChannel) {
@@ -47,7 +55,7 @@ func Pairing(ctx context.Context, options *PairingOptions) error {
 				"type":         "hello",
 				"roomId":       options.RoomID,
 				"signalingKey": options.SignalingKey,
-				"token":        options.Password,
+				"token":        token,
 				"name":         options.DisplayName,
 				"userAgent":    "rtcfs",
 				"services":     []string{"file", "no-client"},
@@ -56,14 +64,37 @@ func Pairing(ctx context.Context, options *PairingOptions) error {
 			dc.SendText(string(j))
 		},
 		OnMessageFunc: func(d *webrtc.DataChannel, msg webrtc.DataChannelMessage) {
-			d.OnMessage(func(msg webrtc.DataChannelMessage) {
-				// TODO: Save credentials
-				log.Println(string(msg.Data))
+			log.Println(string(msg.Data))
+			var reply struct {
+				Name      string `json:"name"`
+				RoomID    string `json:"roomId"`
+				UserAgent string `json:"userAgent"`
+			}
+			if err := json.Unmarshal(msg.Data, &reply); err != nil || reply.Name == "" && reply.UserAgent == "" {
+				saveErr = errors.New("invalid pairing reply")
 				rtcConn.Close()
-			})
+				return
+			}
+			hash, err := rtcConn.RemoteCertificateHash("sha-256")
+			if err != nil {
+				saveErr = err
+				rtcConn.Close()
+				return
+			}
+			peer := &TrustedPeer{Name: reply.Name, RoomID: reply.RoomID, Fingerprint: "sha-256 " + hash, Secret: token, PairedAt: time.Now()}
+			if peer.Name == "" {
+				peer.Name = reply.UserAgent
+			}
+			saveErr = options.PeerStore.Add(peer)
+			log.Println("paired:", peer.Name, peer.Fingerprint)
+			rtcConn.Close()
 		},
 	}}
 
 	rtcConn.Start(dataChannels)
-	return rtcConn.Wait(ctx)
+	err = rtcConn.Wait(ctx)
+	if saveErr != nil {
+		return saveErr
+	}
+	return err
 }
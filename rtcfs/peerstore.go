@@ -0,0 +1,95 @@
+package rtcfs
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+type TrustedPeer struct {
+	Name        string    `json:"name"`
+	RoomID      string    `json:"roomId,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Secret      string    `json:"secret"`
+	PairedAt    time.Time `json:"pairedAt"`
+}
+
+// PeerStore is a list of paired peers saved as a json file.
+type PeerStore struct {
+	path  string
+	lock  sync.Mutex
+	peers []*TrustedPeer
+}
+
+func LoadPeerStore(path string) (*PeerStore, error) {
+	s := &PeerStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return s, json.Unmarshal(data, &s.peers)
+}
+
+func (s *PeerStore) save() error {
+	data, err := json.MarshalIndent(s.peers, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+func (s *PeerStore) Add(peer *TrustedPeer) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.peers = append(s.peers, peer)
+	return s.save()
+}
+
+// Remove removes peers by name or fingerprint.
+func (s *PeerStore) Remove(nameOrFingerprint string) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var peers []*TrustedPeer
+	for _, p := range s.peers {
+		if p.Name != nameOrFingerprint && p.Fingerprint != nameOrFingerprint {
+			peers = append(peers, p)
+		}
+	}
+	removed := len(s.peers) - len(peers)
+	if removed == 0 {
+		return 0, nil
+	}
+	s.peers = peers
+	return removed, s.save()
+}
+
+func (s *PeerStore) List() []*TrustedPeer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*TrustedPeer(nil), s.peers...)
+}
+
+// FindByHMAC returns the peer whose secret matches HMAC(secret, msg).
+func (s *PeerStore) FindByHMAC(msg string, mac []byte) *TrustedPeer {
+	for _, p := range s.List() {
+		if hmac.Equal(authHMAC(p.Secret, msg), mac) {
+			return p
+		}
+	}
+	return nil
+}
+
+// FindBySecret returns the peer by plain secret. (legacy token auth)
+func (s *PeerStore) FindBySecret(secret string) *TrustedPeer {
+	for _, p := range s.List() {
+		if secret != "" && hmac.Equal([]byte(p.Secret), []byte(secret)) {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package rtcfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPeerStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	store, err := LoadPeerStore(path)
+	if err != nil {
+		t.Fatal("LoadPeerStore() error: ", err)
+	}
+
+	err = store.Add(&TrustedPeer{Name: "peer1", Fingerprint: "sha-256 AA", Secret: "secret1", PairedAt: time.Now()})
+	if err != nil {
+		t.Fatal("Add() error: ", err)
+	}
+	store.Add(&TrustedPeer{Name: "peer2", Secret: "secret2", PairedAt: time.Now()})
+
+	store, err = LoadPeerStore(path)
+	if err != nil {
+		t.Fatal("LoadPeerStore() error: ", err)
+	}
+	if len(store.List()) != 2 {
+		t.Fatal("List() error: ", store.List())
+	}
+
+	h := hmac.New(sha256.New, []byte("secret2"))
+	h.Write([]byte("sha-256 BB"))
+	if peer := store.FindByHMAC("sha-256 BB", h.Sum(nil)); peer == nil || peer.Name != "peer2" {
+		t.Error("FindByHMAC() error: ", peer)
+	}
+	if peer := store.FindBySecret("secret1"); peer == nil || peer.Name != "peer1" {
+		t.Error("FindBySecret() error: ", peer)
+	}
+	if peer := store.FindBySecret(""); peer != nil {
+		t.Error("FindBySecret() should be nil: ", peer)
+	}
+
+	n, err := store.Remove("sha-256 AA")
+	if err != nil || n != 1 {
+		t.Fatal("Remove() error: ", n, err)
+	}
+	if peer := store.FindBySecret("secret1"); peer != nil {
+		t.Error("removed peer should not be found: ", peer)
+	}
+}
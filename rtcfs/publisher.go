@@ -1,16 +1,19 @@
 package rtcfs
 
 import (
-	"bytes"
 	"context"
-	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/fs"
 	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/binzume/webrtcfs/ayame"
 	"github.com/binzume/webrtcfs/socfs"
 	"github.com/pion/webrtc/v3"
 )
@@ -29,8 +32,16 @@ func randomStr(l int) string {
 }
 
 func StartRedirector(ctx context.Context, options *ConnectOptions, redirect func(roomId string)) error {
+	return startRedirector(ctx, options, func(roomID string) error {
+		go redirect(roomID)
+		return nil
+	})
+}
+
+// startRedirector redirects peers to temporary rooms. Peers are rejected if redirect returns an error.
+func startRedirector(ctx context.Context, options *ConnectOptions, redirect func(roomId string) error) error {
 	for {
-		rtcConn, err := NewRTCConn(options.SignalingURL, options.DefaultRoomID(), options.SignalingKey)
+		rtcConn, err := newRTCConn(options, options.DefaultRoomID())
 		if err != nil {
 			return err
 		}
@@ -39,11 +50,19 @@ func StartRedirector(ctx context.Context, options *ConnectOptions, redirect func
 			Name: "controlEvent",
 			OnOpenFunc: func(d *webrtc.DataChannel) {
 				roomID := options.RoomID + "." + randomStr(10)
-				go redirect(roomID)
-				j, _ := json.Marshal(map[string]interface{}{
-					"type":   "redirect",
-					"roomId": roomID,
-				})
+				var j []byte
+				if err := redirect(roomID); err != nil {
+					j, _ = json.Marshal(map[string]interface{}{
+						"type":   "authResult",
+						"result": false,
+						"reason": err.Error(),
+					})
+				} else {
+					j, _ = json.Marshal(map[string]interface{}{
+						"type":   "redirect",
+						"roomId": roomID,
+					})
+				}
 				d.SendText(string(j))
 				go func() {
 					time.Sleep(time.Second)
@@ -63,15 +82,180 @@ func StartRedirector(ctx context.Context, options *ConnectOptions, redirect func
 	}
 }
 
+type PublisherOptions struct {
+	// Max authenticated peers
+	MaxPeers int
+	// Max peers which are not authenticated yet
+	MaxPendingPeers int
+	ConnectTimeout  time.Duration
+	IdleTimeout     time.Duration
+	// Active sessions are logged at this interval if it is not zero.
+	StatusInterval time.Duration
+}
+
+type SessionInfo struct {
+	RoomID     string
+	StartTime  time.Time
+	LastActive time.Time
+	Connected  bool
+	Authorized bool
+	User       string
+}
+
+type peerSession struct {
+	lock sync.Mutex
+	info SessionInfo
+}
+
+func (s *peerSession) update(f func(info *SessionInfo)) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f(&s.info)
+}
+
+func (s *peerSession) touch() {
+	s.update(func(info *SessionInfo) { info.LastActive = time.Now() })
+}
+
+func (s SessionInfo) String() string {
+	now := time.Now()
+	return fmt.Sprintf("%s user=%q connected=%v authorized=%v age=%v idle=%v", s.RoomID, s.User, s.Connected, s.Authorized,
+		now.Sub(s.StartTime).Truncate(time.Second), now.Sub(s.LastActive).Truncate(time.Second))
+}
+
+func (s *peerSession) Info() SessionInfo {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.info
+}
+
+// Publisher serves multiple peers. Each peer is redirected to a temporary room.
+type Publisher struct {
+	options  *ConnectOptions
+	pubOpt   *PublisherOptions
+	fsys     fs.FS
+	lock     sync.Mutex
+	sessions map[string]*peerSession
+}
+
+func NewPublisher(options *ConnectOptions, pubOpt *PublisherOptions, fsys fs.FS) *Publisher {
+	return &Publisher{options: options, pubOpt: pubOpt, fsys: fsys, sessions: map[string]*peerSession{}}
+}
+
+func (p *Publisher) Start(ctx context.Context) error {
+	if p.pubOpt.StatusInterval > 0 {
+		go p.logStatus(ctx)
+	}
+	return startRedirector(ctx, p.options, func(roomID string) error {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		authorized := 0
+		for _, s := range p.sessions {
+			if s.Info().Authorized {
+				authorized++
+			}
+		}
+		if p.pubOpt.MaxPeers > 0 && authorized >= p.pubOpt.MaxPeers {
+			log.Println("too many peers")
+			return errors.New("too many peers")
+		}
+		if p.pubOpt.MaxPendingPeers > 0 && len(p.sessions)-authorized >= p.pubOpt.MaxPendingPeers {
+			log.Println("too many pending peers")
+			return errors.New("too many pending peers")
+		}
+		now := time.Now()
+		s := &peerSession{info: SessionInfo{RoomID: roomID, StartTime: now, LastActive: now}}
+		p.sessions[roomID] = s
+		log.Println("temporary room:", roomID, "active sessions:", len(p.sessions))
+		go p.serve(ctx, s)
+		return nil
+	})
+}
+
+func (p *Publisher) serve(ctx context.Context, s *peerSession) {
+	roomID := s.Info().RoomID
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go p.watch(ctx, cancel, s)
+
+	err := publishRoomID(ctx, p.options, roomID, p.fsys, s)
+	if err != nil && err != context.Canceled {
+		log.Println("session error:", roomID, err)
+	}
+
+	p.lock.Lock()
+	delete(p.sessions, roomID)
+	n := len(p.sessions)
+	p.lock.Unlock()
+	log.Println("session end:", roomID, "active sessions:", n)
+}
+
+// watch closes unused or idle sessions.
+func (p *Publisher) watch(ctx context.Context, cancel func(), s *peerSession) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info := s.Info()
+		if !info.Connected && p.pubOpt.ConnectTimeout > 0 && time.Since(info.StartTime) > p.pubOpt.ConnectTimeout {
+			log.Println("connect timeout:", info.RoomID)
+			cancel()
+		} else if info.Connected && p.pubOpt.IdleTimeout > 0 && time.Since(info.LastActive) > p.pubOpt.IdleTimeout {
+			log.Println("idle timeout:", info.RoomID)
+			cancel()
+		}
+	}
+}
+
+func (p *Publisher) logStatus(ctx context.Context) {
+	ticker := time.NewTicker(p.pubOpt.StatusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, s := range p.Sessions() {
+			log.Println("session:", s)
+		}
+	}
+}
+
+// Sessions returns active sessions.
+func (p *Publisher) Sessions() []SessionInfo {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var sessions []SessionInfo
+	for _, s := range p.sessions {
+		sessions = append(sessions, s.Info())
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].StartTime.Before(sessions[j].StartTime) })
+	return sessions
+}
+
 func Publish(ctx context.Context, options *ConnectOptions, fsys fs.FS) error {
 	return PublishRoomID(ctx, options, options.DefaultRoomID(), fsys)
 }
 
 func PublishRoomID(ctx context.Context, options *ConnectOptions, roomID string, fsys fs.FS) error {
-	password := options.Password
-	authorized := password == ""
+	return publishRoomID(ctx, options, roomID, fsys, nil)
+}
+
+func publishRoomID(ctx context.Context, options *ConnectOptions, roomID string, fsys fs.FS, session *peerSession) error {
+	var authorized atomic.Bool
+	authorized.Store(options.Password == "" && len(options.Users) == 0 && (options.PeerStore == nil || len(options.PeerStore.List()) == 0))
+	failures := 0
+	authenticated := false
 
-	rtcConn, err := NewRTCConn(options.SignalingURL, roomID, options.SignalingKey)
+	rtcConn, err := newRTCConn(options, roomID)
 	if err != nil {
 		return err
 	}
@@ -81,12 +265,36 @@ func PublishRoomID(ctx context.Context, options *ConnectOptions, roomID string,
 		}
 	}()
 
+	// The auth deadline starts when a peer appears, even if it doesn't open data channels.
+	var authTimer sync.Once
+	startAuthTimer := func() {
+		if options.AuthTimeout <= 0 {
+			return
+		}
+		authTimer.Do(func() {
+			time.AfterFunc(options.AuthTimeout, func() {
+				if !authorized.Load() {
+					log.Println("auth timeout:", roomID)
+					rtcConn.Close()
+				}
+			})
+		})
+	}
+	if session != nil || rtcConn.IsExistRoom() {
+		startAuthTimer()
+	}
+	rtcConn.OnSignalingMessage = func(msg *ayame.SignalingMessage) { startAuthTimer() }
+
 	fileHander := socfs.NewFSServer(fsys, 8)
+	defer fileHander.Close()
+
+	nonce := randomStr(32)
 
 	dataChannels := []DataChannelHandler{&DataChannelCallback{
 		Name: "fileServer",
 		OnMessageFunc: func(d *webrtc.DataChannel, msg webrtc.DataChannelMessage) {
-			if !authorized {
+			session.touch()
+			if !authorized.Load() {
 				fileHander.ErrorReply(ctx, msg.Data, msg.IsString, func(res *socfs.FileOperationResult) error {
 					if res.IsJSON() {
 						return d.SendText(string(res.ToBytes()))
@@ -106,34 +314,61 @@ func PublishRoomID(ctx context.Context, options *ConnectOptions, roomID string,
 		},
 	}, &DataChannelCallback{
 		Name: "controlEvent",
+		OnOpenFunc: func(d *webrtc.DataChannel) {
+			session.update(func(info *SessionInfo) { info.Connected = true })
+			j, _ := json.Marshal(map[string]interface{}{
+				"type":    "challenge",
+				"nonce":   nonce,
+				"version": AuthProtocolVersion,
+			})
+			d.SendText(string(j))
+		},
 		OnMessageFunc: func(d *webrtc.DataChannel, msg webrtc.DataChannelMessage) {
-			var auth struct {
-				Type       string `json:"type"`
-				Token      string `json:"token"` // TODO: Remove this
-				Fingeprint string `json:"fingerprint"`
-				Hmac       []byte `json:"hmac"`
-			}
+			session.touch()
+			var auth authRequest
 			_ = json.Unmarshal(msg.Data, &auth)
-			if auth.Type == "auth" {
-				if len(auth.Hmac) > 0 {
-					if !rtcConn.ValidateRemoteFingerprint(auth.Fingeprint) {
-						// Broken client or MITM
-						log.Println("fingerprint error: ", auth.Fingeprint)
-					} else {
-						h := hmac.New(sha256.New, []byte(options.Password))
-						h.Write([]byte(auth.Fingeprint))
-						authorized = authorized || bytes.Compare(h.Sum(nil), auth.Hmac) == 0
-					}
+			if auth.Type == "auth" && authenticated {
+				// the user can't be changed in the session.
+				j, _ := json.Marshal(map[string]interface{}{
+					"type":   "authResult",
+					"result": false,
+					"reason": "already authenticated",
+				})
+				d.SendText(string(j))
+			} else if auth.Type == "auth" {
+				var acl *socfs.ACL
+				err := errors.New("too many auth attempts")
+				peer, _ := rtcConn.RemoteCertificateHash("sha-256")
+				if authLimiter.allowPeer(options.RoomID, peer, options.AuthRateLimit, time.Now()) {
+					acl, err = authenticate(options, rtcConn, nonce, &auth)
+				}
+				if err == nil {
+					fileHander.SetACL(acl)
+					authorized.Store(true)
+					authenticated = true
 				} else {
-					authorized = authorized || auth.Token == password
+					failures++
 				}
-				log.Println("auth result:", authorized, string(msg.Data))
-				j, _ := json.Marshal(map[string]interface{}{
+				ok := authorized.Load()
+				log.Println("auth result:", ok, auth.User, err)
+				session.update(func(info *SessionInfo) {
+					info.Authorized = ok
+					info.User = auth.User
+				})
+				result := map[string]interface{}{
 					"type":     "authResult",
-					"result":   authorized,
+					"result":   ok,
 					"services": map[string]interface{}{"file": fileHander.FSCaps()},
-				})
+				}
+				if !ok && err != nil {
+					result["reason"] = err.Error()
+				}
+				j, _ := json.Marshal(result)
 				d.SendText(string(j))
+				if !ok && options.MaxAuthFailures > 0 && failures >= options.MaxAuthFailures {
+					log.Println("too many auth failures:", roomID)
+					time.AfterFunc(time.Second, func() { rtcConn.Close() })
+				}
 			}
 		},
 	}}
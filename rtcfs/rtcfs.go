@@ -1,13 +1,112 @@
 package rtcfs
 
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
 type ConnectOptions struct {
 	SignalingURL string
 	SignalingKey string
 	RoomID       string
 
 	Password string
+	// User name (client)
+	User string
+	// User accounts (publisher)
+	Users []*User
+	// Paired peers (publisher)
+	PeerStore *PeerStore
+	// Reject older auth protocol. 0 or 1 allows v1 clients/servers.
+	MinProtocolVersion int
+	// Reject legacy plaintext token auth (publisher)
+	DisablePlaintextAuth bool
+	// Close the peer if not authorized within this duration. (publisher)
+	AuthTimeout time.Duration
+	// Close the peer after failed auth attempts. (publisher)
+	MaxAuthFailures int
+	// Max auth attempts per minute for each remote peer. The room allows 10 times this. (publisher)
+	AuthRateLimit int
+
+	// Persistent DTLS certificate. (optional)
+	Certificate *webrtc.Certificate
+	// Expected remote fingerprint. e.g. "sha-256 AB:CD:..." (optional)
+	RemoteFingerprint string
+}
+
+// User is an account with restricted access.
+type User struct {
+	Name     string
+	Password string
+	// Allowed path prefixes. Empty means all paths.
+	Paths    []string
+	Writable bool
+}
+
+func (o *ConnectOptions) findUser(name string) *User {
+	for _, u := range o.Users {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
 }
 
 func (o *ConnectOptions) DefaultRoomID() string {
 	return o.RoomID
 }
+
+// LoadOrCreateCertificate loads a PEM encoded certificate or generates new one.
+func LoadOrCreateCertificate(path string) (*webrtc.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return webrtc.CertificateFromPEM(string(data))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	cert, err := webrtc.NewCertificate(key, x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "rtcfs"},
+		NotBefore:    time.Now().AddDate(0, 0, -1),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		Version:      2,
+	})
+	if err != nil {
+		return nil, err
+	}
+	pem, err := cert.PEM()
+	if err != nil {
+		return nil, err
+	}
+	return cert, os.WriteFile(path, []byte(pem), 0600)
+}
+
+// CertificateFingerprint returns fingerprint string. e.g. "sha-256 AB:CD:..."
+func CertificateFingerprint(cert *webrtc.Certificate) (string, error) {
+	fingerprints, err := cert.GetFingerprints()
+	if err != nil {
+		return "", err
+	}
+	if len(fingerprints) == 0 {
+		return "", errors.New("no fingerprints")
+	}
+	return fingerprints[0].Algorithm + " " + fingerprints[0].Value, nil
+}
@@ -0,0 +1,799 @@
+package rtcfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/binzume/webrtcfs/ayame"
+	"github.com/binzume/webrtcfs/socfs"
+	"github.com/pion/webrtc/v3"
+)
+
+func startSignalingServer(t *testing.T) (*ConnectOptions, *ayame.Server) {
+	signaling := ayame.NewServer("")
+	server := httptest.NewServer(signaling)
+	t.Cleanup(server.Close)
+	return &ConnectOptions{
+		SignalingURL: "ws" + strings.TrimPrefix(server.URL, "http"),
+		RoomID:       "test-room",
+		Password:     "test-password",
+	}, signaling
+}
+
+// waitForRoom waits until n clients are in the room.
+func waitForRoom(t *testing.T, signaling *ayame.Server, roomID string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for signaling.Clients(roomID) != n {
+		if time.Now().After(deadline) {
+			t.Fatal("waitForRoom timeout: ", roomID, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startPublish starts Publish and waits until the publisher has joined the room.
+func startPublish(ctx context.Context, t *testing.T, signaling *ayame.Server, options *ConnectOptions, fsys fs.FS) <-chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- Publish(ctx, options, fsys) }()
+	waitForRoom(t, signaling, options.DefaultRoomID(), 1)
+	return done
+}
+
+func TestPublish(t *testing.T) {
+	options, signaling := startSignalingServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	startPublish(ctx, t, signaling, options, os.DirFS("../testdata"))
+
+	rtcConn, client, err := GetClinet(ctx, options, &ClientOptions{})
+	if err != nil {
+		t.Fatal("GetClinet() error: ", err)
+	}
+	defer rtcConn.Close()
+
+	data, err := fs.ReadFile(client, "test.png")
+	if err != nil {
+		t.Fatal("ReadFile() error: ", err)
+	}
+	expected, _ := os.ReadFile("../testdata/test.png")
+	if len(data) != len(expected) {
+		t.Error("ReadFile() size error: ", len(data), len(expected))
+	}
+}
+
+func TestRTCConn_handleSignalingMessage(t *testing.T) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	conn := &RTCConn{PC: pc}
+
+	err = conn.handleSignalingMessage(&ayame.SignalingMessage{Type: "candidate"})
+	if err == nil {
+		t.Error("invalid candidate should be error")
+	}
+	err = conn.handleSignalingMessage(&ayame.SignalingMessage{Type: "offer", SDP: "invalid"})
+	if err == nil {
+		t.Error("invalid offer should be error")
+	}
+	err = conn.handleSignalingMessage(&ayame.SignalingMessage{Type: "answer", SDP: "invalid"})
+	if err == nil {
+		t.Error("invalid answer should be error")
+	}
+}
+
+func TestRTCConn_restartICE(t *testing.T) {
+	options, _ := startSignalingServer(t)
+	var conns []*RTCConn
+	for i := 0; i < 2; i++ {
+		conn, err := newRTCConn(options, options.RoomID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.ICERestartTimeout = 2 * time.Second
+		conn.Start([]DataChannelHandler{&DataChannelCallback{Name: "test"}})
+		conns = append(conns, conn)
+	}
+	answerer, offerer := conns[0], conns[1]
+
+	waitFor := func(msg string, cond func() bool) {
+		t.Helper()
+		for i := 0; i < 100 && !cond(); i++ {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if !cond() {
+			t.Fatal(msg)
+		}
+	}
+	connected := func() bool {
+		return answerer.PC.ConnectionState() == webrtc.PeerConnectionStateConnected &&
+			offerer.PC.ConnectionState() == webrtc.PeerConnectionStateConnected
+	}
+	waitFor("not connected", connected)
+
+	for _, c := range []*RTCConn{offerer, answerer} {
+		remote := c.PC.RemoteDescription().SDP
+		peer := answerer
+		if c == answerer {
+			peer = offerer
+		}
+		peerRemote := peer.PC.RemoteDescription().SDP
+
+		c.onConnectionStateChange(webrtc.PeerConnectionStateDisconnected)
+		c.lock.Lock()
+		restarts := c.restarts
+		c.lock.Unlock()
+		if restarts != 1 {
+			t.Error("restarts should be 1: ", restarts)
+		}
+		waitFor("restart offer not received", func() bool {
+			return peer.PC.RemoteDescription().SDP != peerRemote && c.PC.RemoteDescription().SDP != remote
+		})
+		waitFor("restarts should be reset", func() bool {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			return c.restarts == 0 && c.restartTimer == nil
+		})
+		waitFor("not connected after restart", connected)
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	options, signaling := startSignalingServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	publisher := NewPublisher(options, &PublisherOptions{MaxPeers: 2, ConnectTimeout: 10 * time.Second}, os.DirFS("../testdata"))
+	go publisher.Start(ctx)
+	waitForRoom(t, signaling, options.DefaultRoomID(), 1)
+
+	rtcConn, client, err := GetClinet(ctx, options, &ClientOptions{MaxRedirect: 1})
+	if err != nil {
+		t.Fatal("GetClinet() error: ", err)
+	}
+	_, err = client.Stat("test.png")
+	if err != nil {
+		t.Fatal("Stat() error: ", err)
+	}
+	if sessions := publisher.Sessions(); len(sessions) != 1 || !sessions[0].Authorized {
+		t.Error("Sessions() error: ", sessions)
+	}
+
+	rtcConn.Close()
+	for len(publisher.Sessions()) > 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("session not closed")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// requestRoom connects to the redirector and returns the first message.
+func requestRoom(t *testing.T, signaling *ayame.Server, options *ConnectOptions) map[string]interface{} {
+	t.Helper()
+	waitForRoom(t, signaling, options.DefaultRoomID(), 1)
+	rtcConn, err := newRTCConn(options, options.DefaultRoomID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		// wait for the redirector to leave the room
+		rtcConn.Close()
+		waitForRoom(t, signaling, options.DefaultRoomID(), 0)
+	}()
+	events := make(chan map[string]interface{}, 1)
+	rtcConn.Start([]DataChannelHandler{&DataChannelCallback{
+		Name: "controlEvent",
+		OnMessageFunc: func(d *webrtc.DataChannel, msg webrtc.DataChannelMessage) {
+			var event map[string]interface{}
+			json.Unmarshal(msg.Data, &event)
+			select {
+			case events <- event:
+			default:
+			}
+		},
+	}})
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(10 * time.Second):
+		t.Fatal("no response from the redirector")
+	}
+	return nil
+}
+
+func TestPublisher_pending(t *testing.T) {
+	options, signaling := startSignalingServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	publisher := NewPublisher(options, &PublisherOptions{MaxPeers: 1, MaxPendingPeers: 1, ConnectTimeout: 5 * time.Second}, os.DirFS("../testdata"))
+	go publisher.Start(ctx)
+
+	// the peer doesn't join the temporary room
+	if event := requestRoom(t, signaling, options); event["type"] != "redirect" {
+		t.Fatal("should be redirected: ", event)
+	}
+	if sessions := publisher.Sessions(); len(sessions) != 1 || sessions[0].Authorized {
+		t.Error("Sessions() error: ", sessions)
+	}
+	if event := requestRoom(t, signaling, options); event["result"] != false || event["reason"] != "too many pending peers" {
+		t.Error("pending peers should be limited: ", event)
+	}
+
+	// reclaimed by ConnectTimeout
+	for len(publisher.Sessions()) > 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("session not closed")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if event := requestRoom(t, signaling, options); event["type"] != "redirect" {
+		t.Error("should be redirected after the session is closed: ", event)
+	}
+}
+
+func TestLoadOrCreateCertificate(t *testing.T) {
+	path := t.TempDir() + "/cert.pem"
+	cert, err := LoadOrCreateCertificate(path)
+	if err != nil {
+		t.Fatal("LoadOrCreateCertificate() error: ", err)
+	}
+	cert2, err := LoadOrCreateCertificate(path)
+	if err != nil {
+		t.Fatal("LoadOrCreateCertificate() error: ", err)
+	}
+	f1, _ := CertificateFingerprint(cert)
+	f2, _ := CertificateFingerprint(cert2)
+	if f1 == "" || f1 != f2 {
+		t.Error("fingerprint changed: ", f1, f2)
+	}
+}
+
+func TestPublish_RemoteFingerprint(t *testing.T) {
+	options, signaling := startSignalingServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cert, err := LoadOrCreateCertificate(t.TempDir() + "/cert.pem")
+	if err != nil {
+		t.Fatal("LoadOrCreateCertificate() error: ", err)
+	}
+	fingerprint, _ := CertificateFingerprint(cert)
+	pubOptions := *options
+	pubOptions.Certificate = cert
+
+	startPublish(ctx, t, signaling, &pubOptions, os.DirFS("../testdata"))
+
+	clientOptions := *options
+	clientOptions.RemoteFingerprint = "sha-256 00:00:00"
+	_, _, err = GetClinet(ctx, &clientOptions, &ClientOptions{})
+	if err == nil {
+		t.Fatal("GetClinet() should be error")
+	}
+
+	pubOptions2 := pubOptions
+	pubOptions2.RoomID = "test-room2"
+	startPublish(ctx, t, signaling, &pubOptions2, os.DirFS("../testdata"))
+
+	clientOptions.RoomID = "test-room2"
+	clientOptions.RemoteFingerprint = fingerprint
+	rtcConn, client, err := GetClinet(ctx, &clientOptions, &ClientOptions{})
+	if err != nil {
+		t.Fatal("GetClinet() error: ", err)
+	}
+	defer rtcConn.Close()
+	if _, err := fs.Stat(client, "test.png"); err != nil {
+		t.Fatal("Stat() error: ", err)
+	}
+}
+
+func TestPublish_TrustedPeer(t *testing.T) {
+	options, signaling := startSignalingServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cert, err := LoadOrCreateCertificate(t.TempDir() + "/cert.pem")
+	if err != nil {
+		t.Fatal("LoadOrCreateCertificate() error: ", err)
+	}
+	fingerprint, _ := CertificateFingerprint(cert)
+	store, err := LoadPeerStore(t.TempDir() + "/peers.json")
+	if err != nil {
+		t.Fatal("LoadPeerStore() error: ", err)
+	}
+	store.Add(&TrustedPeer{Name: "other", Fingerprint: "sha-256 00:00:00", Secret: "secret1", PairedAt: time.Now()})
+	store.Add(&TrustedPeer{Name: "peer", Fingerprint: fingerprint, Secret: "secret2", PairedAt: time.Now()})
+
+	pubOptions := *options
+	pubOptions.PeerStore = store
+	startPublish(ctx, t, signaling, &pubOptions, os.DirFS("../testdata"))
+
+	clientOptions := *options
+	clientOptions.Certificate = cert
+	clientOptions.Password = "secret1"
+	if _, _, err = GetClinet(ctx, &clientOptions, &ClientOptions{}); err == nil {
+		t.Fatal("GetClinet() should be error for unmatched fingerprint")
+	}
+
+	pubOptions2 := pubOptions
+	pubOptions2.RoomID = "test-room2"
+	startPublish(ctx, t, signaling, &pubOptions2, os.DirFS("../testdata"))
+
+	clientOptions.RoomID = "test-room2"
+	clientOptions.Password = "secret2"
+	rtcConn, client, err := GetClinet(ctx, &clientOptions, &ClientOptions{})
+	if err != nil {
+		t.Fatal("GetClinet() error: ", err)
+	}
+	defer rtcConn.Close()
+	if _, err := fs.Stat(client, "test.png"); err != nil {
+		t.Fatal("Stat() error: ", err)
+	}
+}
+
+func TestPublish_User(t *testing.T) {
+	options, signaling := startSignalingServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "dir"), 0755)
+	os.WriteFile(filepath.Join(dir, "dir", "a.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(dir, "test.txt"), []byte("secret"), 0644)
+
+	pubOptions := *options
+	pubOptions.Users = []*User{
+		{Name: "guest", Password: "guest-password", Paths: []string{"/dir"}},
+		{Name: "editor", Password: "editor-password", Paths: []string{"/dir"}, Writable: true},
+	}
+
+	for i, user := range pubOptions.Users {
+		roomOptions := pubOptions
+		roomOptions.RoomID = options.RoomID + user.Name
+		startPublish(ctx, t, signaling, &roomOptions, socfs.NewWritableDirFS(dir))
+
+		clientOptions := roomOptions
+		clientOptions.User = user.Name
+		clientOptions.Password = user.Password
+		rtcConn, client, err := GetClinet(ctx, &clientOptions, &ClientOptions{})
+		if err != nil {
+			t.Fatal("GetClinet() error: ", err)
+		}
+		defer rtcConn.Close()
+
+		if _, err := fs.ReadFile(client, "test.txt"); err == nil {
+			t.Error("ReadFile() should be error: ", user.Name)
+		}
+		if data, err := fs.ReadFile(client, "dir/a.txt"); err != nil || string(data) != "hello" {
+			t.Error("ReadFile() error: ", user.Name, err)
+		}
+		stat, err := fs.Stat(client, "dir/a.txt")
+		if err != nil {
+			t.Fatal("Stat() error: ", err)
+		}
+		if writable := stat.Mode()&0200 != 0; writable != user.Writable {
+			t.Error("writable flag error: ", user.Name, writable)
+		}
+		name := fmt.Sprintf("dir/%d.txt", i)
+		w, err := client.Create(name)
+		if err == nil {
+			err = w.Close()
+		}
+		if (err == nil) != user.Writable {
+			t.Error("Create() error: ", user.Name, err)
+		}
+		if w, err := client.Create("new.txt"); err == nil {
+			w.Close()
+			t.Error("Create() should be error: ", user.Name)
+		}
+	}
+}
+
+func TestPublish_AuthTimeout(t *testing.T) {
+	options, signaling := startSignalingServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pubOptions := *options
+	pubOptions.AuthTimeout = 500 * time.Millisecond
+	// connect without auth. peers which don't open controlEvent are also closed.
+	for _, channels := range [][]DataChannelHandler{
+		{&DataChannelCallback{Name: "fileServer"}, &DataChannelCallback{Name: "controlEvent"}},
+		{&DataChannelCallback{Name: "fileServer"}},
+		{},
+	} {
+		done := startPublish(ctx, t, signaling, &pubOptions, os.DirFS("../testdata"))
+
+		rtcConn, err := newRTCConn(options, options.RoomID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rtcConn.Start(channels)
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Error("peer should be closed: ", len(channels))
+		}
+		rtcConn.Close()
+		waitForRoom(t, signaling, options.RoomID, 0)
+	}
+}
+
+func TestPublish_RepeatedAuth(t *testing.T) {
+	options, signaling := startSignalingServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	startPublish(ctx, t, signaling, options, os.DirFS("../testdata"))
+
+	rtcConn, err := newRTCConn(options, options.RoomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtcConn.Close()
+	results := make(chan map[string]interface{}, 2)
+	rtcConn.Start([]DataChannelHandler{&DataChannelCallback{
+		Name: "controlEvent",
+		OnMessageFunc: func(d *webrtc.DataChannel, msg webrtc.DataChannelMessage) {
+			var event map[string]interface{}
+			json.Unmarshal(msg.Data, &event)
+			if event["type"] == "challenge" {
+				auth, _ := newAuthRequest(rtcConn, options, event["nonce"].(string))
+				j, _ := json.Marshal(auth)
+				d.SendText(string(j))
+			} else if event["type"] == "authResult" {
+				results <- event
+				if event["result"] == true {
+					j, _ := json.Marshal(&authRequest{Type: "auth", User: "other", Version: AuthProtocolVersion})
+					d.SendText(string(j))
+				}
+			}
+		},
+	}})
+
+	for i, expected := range []bool{true, false} {
+		select {
+		case event := <-results:
+			if event["result"] != expected {
+				t.Error("auth result mismatch: ", i, event)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("no auth result: ", i)
+		}
+	}
+}
+
+func TestShell_recursive(t *testing.T) {
+	options, signaling := startSignalingServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	remoteDir := t.TempDir()
+	startPublish(ctx, t, signaling, options, socfs.NewWritableDirFS(remoteDir))
+
+	rtcConn, client, err := GetClinet(ctx, options, &ClientOptions{})
+	if err != nil {
+		t.Fatal("GetClinet() error: ", err)
+	}
+	defer rtcConn.Close()
+
+	localDir := t.TempDir()
+	os.MkdirAll(filepath.Join(localDir, "src", "sub"), 0755)
+	os.WriteFile(filepath.Join(localDir, "src", "a.txt"), []byte("aaa"), 0644)
+	os.WriteFile(filepath.Join(localDir, "src", "sub", "b.txt"), []byte("bbbbb"), 0644)
+	symlinks := os.Symlink("sub/b.txt", filepath.Join(localDir, "src", "link.txt")) == nil
+	if symlinks {
+		os.Symlink("sub", filepath.Join(localDir, "src", "dirlink"))
+	}
+
+	err = shellExecCmd(ctx, client, "/", "push", []string{"-r", filepath.Join(localDir, "src")})
+	if err != nil {
+		t.Fatal("push error: ", err)
+	}
+	data, err := os.ReadFile(filepath.Join(remoteDir, "src", "sub", "b.txt"))
+	if err != nil || string(data) != "bbbbb" {
+		t.Error("push failed: ", string(data), err)
+	}
+	if symlinks {
+		if data, err := os.ReadFile(filepath.Join(remoteDir, "src", "link.txt")); string(data) != "bbbbb" {
+			t.Error("push symlink failed: ", string(data), err)
+		}
+		os.Symlink("a.txt", filepath.Join(remoteDir, "src", "rlink.txt"))
+		os.Symlink(".", filepath.Join(remoteDir, "src", "rdirlink"))
+	}
+
+	for _, ext := range []bool{false, true} {
+		client.ExtendedStat = ext
+		dst := filepath.Join(localDir, fmt.Sprint("dst", ext))
+		err = shellExecCmd(ctx, client, "/", "pull", []string{"-r", "src", dst})
+		if err != nil {
+			t.Fatal("pull error: ", err)
+		}
+		data, err = os.ReadFile(filepath.Join(dst, "sub", "b.txt"))
+		if err != nil || string(data) != "bbbbb" {
+			t.Error("pull failed: ", string(data), err)
+		}
+		if data, err := os.ReadFile(filepath.Join(dst, "rlink.txt")); symlinks && string(data) != "aaa" {
+			t.Error("pull symlink failed: ", string(data), err)
+		}
+	}
+	client.ExtendedStat = false
+	os.Rename(filepath.Join(localDir, "dstfalse"), filepath.Join(localDir, "dst"))
+
+	stats := &transferStats{}
+	opt := &transferOptions{update: true}
+	stat, _ := fs.Stat(client, "/src/a.txt")
+	err = shellPullFile(ctx, client, "/src/a.txt", filepath.Join(localDir, "dst", "a.txt"), stat, opt, stats)
+	if err != nil || stats.skipped != 1 {
+		t.Error("matching file should be skipped: ", stats, err)
+	}
+	cancelled, cancel2 := context.WithCancel(ctx)
+	cancel2()
+	err = shellPullFile(cancelled, client, "/src/a.txt", filepath.Join(localDir, "cancelled.txt"), stat, &transferOptions{}, stats)
+	if !errors.Is(err, context.Canceled) {
+		t.Error("pull should be cancelled: ", err)
+	}
+
+	client.Mkdir("/backup", fs.ModePerm)
+	if err := shellExecCmd(ctx, client, "/", "cp", []string{"src", "backup"}); err != nil {
+		t.Fatal("cp error: ", err)
+	}
+	if err := shellExecCmd(ctx, client, "/", "mv", []string{"backup/src/a.txt", "backup/c.txt"}); err != nil {
+		t.Fatal("mv error: ", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(remoteDir, "backup", "c.txt")); string(data) != "aaa" {
+		t.Error("mv failed: ", string(data))
+	}
+	if err := shellExecCmd(ctx, client, "/", "rm", []string{"-r", "backup"}); err != nil {
+		t.Fatal("rm error: ", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "backup")); !os.IsNotExist(err) {
+		t.Error("backup should be removed: ", err)
+	}
+}
+
+func TestShell_resume(t *testing.T) {
+	options, signaling := startSignalingServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	remoteDir := t.TempDir()
+	startPublish(ctx, t, signaling, options, socfs.NewWritableDirFS(remoteDir))
+
+	rtcConn, client, err := GetClinet(ctx, options, &ClientOptions{})
+	if err != nil {
+		t.Fatal("GetClinet() error: ", err)
+	}
+	defer rtcConn.Close()
+
+	data := make([]byte, 200000)
+	rand.Read(data)
+	localDir := t.TempDir()
+	os.WriteFile(filepath.Join(remoteDir, "a.bin"), data, 0644)
+	os.WriteFile(filepath.Join(localDir, "a.bin"), data[:100000], 0644)
+	os.WriteFile(filepath.Join(localDir, "b.bin"), data, 0644)
+	os.WriteFile(filepath.Join(remoteDir, "b.bin"), data[:120000], 0644)
+	os.WriteFile(filepath.Join(localDir, "c.bin"), make([]byte, 100000), 0644) // broken
+
+	opt := &transferOptions{resume: true}
+	stat, _ := fs.Stat(client, "a.bin")
+	stats := &transferStats{}
+	err = shellPullFile(ctx, client, "a.bin", filepath.Join(localDir, "a.bin"), stat, opt, stats)
+	if err != nil || stats.bytes != 100000 {
+		t.Error("pull should be resumed: ", stats, err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(localDir, "a.bin")); !bytes.Equal(b, data) {
+		t.Error("pull data mismatch")
+	}
+
+	stats = &transferStats{}
+	err = shellPullFile(ctx, client, "a.bin", filepath.Join(localDir, "c.bin"), stat, opt, stats)
+	if err != nil || stats.bytes != 200000 {
+		t.Error("broken file should be transferred from the beginning: ", stats, err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(localDir, "c.bin")); !bytes.Equal(b, data) {
+		t.Error("pull data mismatch")
+	}
+
+	lstat, _ := os.Stat(filepath.Join(localDir, "b.bin"))
+	stats = &transferStats{}
+	err = shellPushFile(ctx, client, filepath.Join(localDir, "b.bin"), "b.bin", lstat, opt, stats)
+	if err != nil || stats.bytes != 80000 {
+		t.Error("push should be resumed: ", stats, err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(remoteDir, "b.bin")); !bytes.Equal(b, data) {
+		t.Error("push data mismatch")
+	}
+}
+
+func TestSync(t *testing.T) {
+	options, signaling := startSignalingServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	remoteDir := t.TempDir()
+	startPublish(ctx, t, signaling, options, socfs.NewWritableDirFS(remoteDir))
+
+	rtcConn, client, err := GetClinet(ctx, options, &ClientOptions{})
+	if err != nil {
+		t.Fatal("GetClinet() error: ", err)
+	}
+	defer rtcConn.Close()
+
+	os.MkdirAll(filepath.Join(remoteDir, "dir", "sub"), 0755)
+	os.WriteFile(filepath.Join(remoteDir, "dir", "a.txt"), []byte("aaa"), 0644)
+	os.WriteFile(filepath.Join(remoteDir, "dir", "sub", "b.txt"), []byte("bbb"), 0644)
+	os.Chmod(filepath.Join(remoteDir, "dir", "sub", "b.txt"), 0600)
+	localDir := filepath.Join(t.TempDir(), "local")
+
+	err = Sync(ctx, client, localDir, "/dir", &SyncOptions{Mode: "pull", DryRun: true})
+	if _, serr := os.Stat(localDir); err != nil || serr == nil {
+		t.Fatal("dry-run should not change files: ", err)
+	}
+	err = Sync(ctx, client, localDir, "/dir", &SyncOptions{Mode: "pull", Perms: true})
+	if err != nil {
+		t.Fatal("Sync() error: ", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(localDir, "sub", "b.txt")); string(data) != "bbb" {
+		t.Error("pull failed: ", string(data))
+	}
+	if stat, err := os.Stat(filepath.Join(localDir, "sub", "b.txt")); err != nil || stat.Mode().Perm() != 0600 {
+		t.Error("permission should be copied: ", stat.Mode(), err)
+	}
+
+	if client.ExtendedStat {
+		t.Error("ExtendedStat should be restored")
+	}
+
+	// same size local changes are reverted
+	bPath := filepath.Join(localDir, "sub", "b.txt")
+	os.WriteFile(bPath, []byte("xxx"), 0600)
+	if err := Sync(ctx, client, localDir, "/dir", &SyncOptions{Mode: "pull"}); err != nil {
+		t.Fatal("Sync() error: ", err)
+	}
+	if data, _ := os.ReadFile(bPath); string(data) != "bbb" {
+		t.Error("local change should be reverted: ", string(data))
+	}
+	stat, _ := os.Stat(bPath)
+	os.WriteFile(bPath, []byte("yyy"), 0600)
+	os.Chtimes(bPath, time.Now(), stat.ModTime())
+	if err := Sync(ctx, client, localDir, "/dir", &SyncOptions{Mode: "pull", Hash: true}); err != nil {
+		t.Fatal("Sync() error: ", err)
+	}
+	if data, _ := os.ReadFile(bPath); string(data) != "bbb" {
+		t.Error("checksum should be compared: ", string(data))
+	}
+
+	os.WriteFile(filepath.Join(localDir, "c.txt"), []byte("ccc"), 0644)
+	os.Remove(filepath.Join(localDir, "a.txt"))
+	err = Sync(ctx, client, localDir, "/dir", &SyncOptions{Mode: "push", Delete: true, Hash: true})
+	if err != nil {
+		t.Fatal("Sync() error: ", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(remoteDir, "dir", "c.txt")); string(data) != "ccc" {
+		t.Error("push failed: ", string(data))
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "dir", "a.txt")); !os.IsNotExist(err) {
+		t.Error("a.txt should be deleted: ", err)
+	}
+
+	os.WriteFile(filepath.Join(remoteDir, "dir", "d.txt"), []byte("ddd"), 0644)
+	os.WriteFile(filepath.Join(localDir, "e.txt"), []byte("eee"), 0644)
+	err = Sync(ctx, client, localDir, "/dir", &SyncOptions{Mode: "both"})
+	if err != nil {
+		t.Fatal("Sync() error: ", err)
+	}
+	if _, err := os.Stat(filepath.Join(localDir, "d.txt")); err != nil {
+		t.Error("d.txt should be pulled: ", err)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "dir", "e.txt")); err != nil {
+		t.Error("e.txt should be pushed: ", err)
+	}
+
+	// conflict
+	past := time.Now().Add(-time.Hour)
+	os.WriteFile(filepath.Join(remoteDir, "dir", "d.txt"), []byte("remote"), 0644)
+	os.WriteFile(filepath.Join(localDir, "d.txt"), []byte("local"), 0644)
+	os.WriteFile(filepath.Join(remoteDir, "dir", "e.txt"), []byte("remote"), 0644)
+	os.Chtimes(filepath.Join(remoteDir, "dir", "e.txt"), past, past)
+	err = Sync(ctx, client, localDir, "/dir", &SyncOptions{Mode: "both"})
+	if err == nil {
+		t.Error("Sync() should report conflicts")
+	}
+	if data, _ := os.ReadFile(filepath.Join(localDir, "d.txt")); string(data) != "local" {
+		t.Error("conflicted file should not be changed: ", string(data))
+	}
+	if data, _ := os.ReadFile(filepath.Join(localDir, "e.txt")); string(data) != "remote" {
+		t.Error("remote change should be pulled even if older: ", string(data))
+	}
+
+	// symlinks are synced as the linked files
+	os.MkdirAll(filepath.Join(remoteDir, "dir2"), 0755)
+	os.WriteFile(filepath.Join(remoteDir, "dir2", "a.txt"), []byte("aaa"), 0644)
+	localDir2 := t.TempDir()
+	if os.Symlink("a.txt", filepath.Join(remoteDir, "dir2", "link.txt")) == nil {
+		os.Symlink(".", filepath.Join(remoteDir, "dir2", "dirlink"))
+		for i := 0; i < 2; i++ {
+			if err := Sync(ctx, client, localDir2, "/dir2", &SyncOptions{Mode: "pull"}); err != nil {
+				t.Fatal("Sync() symlink error: ", err)
+			}
+		}
+		if data, _ := os.ReadFile(filepath.Join(localDir2, "link.txt")); string(data) != "aaa" {
+			t.Error("symlink should be pulled: ", string(data))
+		}
+		os.Symlink("a.txt", filepath.Join(localDir2, "llink.txt"))
+		if err := Sync(ctx, client, localDir2, "/dir2", &SyncOptions{Mode: "push"}); err != nil {
+			t.Fatal("Sync() symlink error: ", err)
+		}
+	}
+
+	// a file in one side and a directory in the other side
+	os.MkdirAll(filepath.Join(remoteDir, "dir2", "x"), 0755)
+	os.WriteFile(filepath.Join(remoteDir, "dir2", "x", "a.txt"), []byte("aaa"), 0644)
+	os.WriteFile(filepath.Join(localDir2, "x"), []byte("xxx"), 0644)
+	for _, mode := range []string{"pull", "push", "both"} {
+		err = Sync(ctx, client, localDir2, "/dir2", &SyncOptions{Mode: mode, Delete: mode != "both"})
+		if err == nil || !strings.Contains(err.Error(), "conflicts") {
+			t.Error("Sync() should report conflicts: ", mode, err)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(localDir2, "x")); string(data) != "xxx" {
+		t.Error("conflicted file should not be changed: ", string(data))
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "dir2", "x", "a.txt")); err != nil {
+		t.Error("conflicted directory should not be changed: ", err)
+	}
+}
+
+type fakeHasher []byte
+
+func (h fakeHasher) Hash(name, algorithm string, pos, size int64) (*socfs.HashResult, error) {
+	data := h[pos:]
+	if size < int64(len(data)) {
+		data = data[:size]
+	}
+	sum := sha256.Sum256(data)
+	return &socfs.HashResult{Algorithm: algorithm, Hash: hex.EncodeToString(sum[:]), Size: int64(len(data))}, nil
+}
+
+func TestCompareHash(t *testing.T) {
+	defer func(n int64) { hashChunkSize = n }(hashChunkSize)
+	hashChunkSize = 4
+
+	localPath := filepath.Join(t.TempDir(), "test.txt")
+	for _, data := range []string{"", "abcd", "abcdefghij"} {
+		os.WriteFile(localPath, []byte(data), 0644)
+		if same, err := compareHash(fakeHasher(data), "test.txt", localPath); err != nil || !same {
+			t.Error("compareHash() should be true: ", data, err)
+		}
+		if same, _ := compareHash(fakeHasher(data+"x"), "test.txt", localPath); same {
+			t.Error("compareHash() should be false: ", data)
+		}
+	}
+	os.WriteFile(localPath, []byte("abcdefghij"), 0644)
+	if same, _ := compareHash(fakeHasher("abcdXfghij"), "test.txt", localPath); same {
+		t.Error("compareHash() should be false")
+	}
+}
@@ -2,8 +2,12 @@ package rtcfs
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/fs"
@@ -11,7 +15,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/binzume/webrtcfs/socfs"
 )
@@ -23,28 +30,49 @@ func shellListFiles(ctx context.Context, fsys fs.FS, cwd, arg string) error {
 		fmt.Println(ent.Mode(), "\t", ent.Size(), "\t", ent.Type, "\t", path)
 	}
 	fpath := path.Join(cwd, arg)
-	if strings.HasSuffix(fpath, "/**") {
-		return fs.WalkDir(fsys, strings.TrimSuffix(fpath, "/**"), func(path string, d fs.DirEntry, err error) error {
-			printInfo(d, path)
-			return err
+	if root, pattern, ok := strings.Cut(fpath, "/**"); ok {
+		// e.g. "/dir/**" or "/dir/**/*.jpg"
+		pattern = strings.TrimPrefix(pattern, "/")
+		if root == "" {
+			root = "/"
+		}
+		if client, ok := fsys.(*socfs.FSClient); ok {
+			err := shellSearch(ctx, client, root, pattern)
+			if !errors.Is(err, socfs.ErrUnsupported) {
+				return err
+			}
+		}
+		return fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ok, _ := path.Match(pattern, d.Name()); ok || pattern == "" {
+				printInfo(d, p)
+			}
+			return nil
 		})
 	} else if fsys, ok := fsys.(socfs.OpenDirFS); ok {
 		dir, err := fsys.OpenDir(fpath)
 		if err != nil {
 			return err
 		}
+		defer dir.Close()
+		var entries []fs.DirEntry
 		for {
 			files, err := dir.ReadDir(200)
-			for _, f := range files {
-				printInfo(f, f.Name())
-			}
-			if err != nil {
-				if err == io.EOF {
-					err = nil
-				}
+			entries = append(entries, files...)
+			if err == io.EOF {
+				break
+			} else if err != nil {
 				return err
 			}
 		}
+		// Directory cursors return entries in the order of the server's file system.
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		for _, f := range entries {
+			printInfo(f, f.Name())
+		}
+		return nil
 	} else if fsys, ok := fsys.(fs.ReadDirFS); ok {
 		files, err := fsys.ReadDir(fpath)
 		for _, f := range files {
@@ -56,6 +84,31 @@ func shellListFiles(ctx context.Context, fsys fs.FS, cwd, arg string) error {
 	}
 }
 
+func shellSearch(ctx context.Context, client *socfs.FSClient, root, pattern string) error {
+	d, err := client.Search(root, &socfs.SearchOptions{Pattern: pattern})
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		files, err := d.ReadDir(1000)
+		for _, f := range files {
+			info, _ := f.Info()
+			if ent, ok := info.Sys().(*socfs.FileEntry); ok {
+				fmt.Println(ent.Mode(), "\t", ent.Size(), "\t", ent.Type, "\t", ent.Path)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
 func shellCat(ctx context.Context, fsys fs.FS, cwd, arg string) error {
 	fpath := path.Join(cwd, arg)
 	r, err := fsys.Open(fpath)
@@ -67,50 +120,423 @@ func shellCat(ctx context.Context, fsys fs.FS, cwd, arg string) error {
 	return err
 }
 
-func shellPullFile(ctx context.Context, fsys fs.FS, cwd, arg string) error {
-	fpath := path.Join(cwd, arg)
-	stat, err := fs.Stat(fsys, fpath)
+type transferStats struct {
+	files   int
+	skipped int
+	bytes   int64
+	start   time.Time
+}
+
+func (s *transferStats) String() string {
+	return fmt.Sprintf("%d files, %d bytes, %d skipped (%v)", s.files, s.bytes, s.skipped, time.Since(s.start).Round(time.Millisecond))
+}
+
+type transferOptions struct {
+	recursive bool
+	update    bool // skip files that already match
+	resume    bool // continue interrupted transfers
+	verify    bool // compare checksum after transfer
+}
+
+const resumeCheckSize = 65536
+
+// resumeOffset returns the offset to continue the transfer. The last block before the offset must be the same.
+func resumeOffset(src, dst io.ReaderAt, srcSize, dstSize int64) int64 {
+	if dstSize <= 0 || dstSize > srcSize {
+		return 0
+	}
+	n := int64(resumeCheckSize)
+	if n > dstSize {
+		n = dstSize
+	}
+	b1 := make([]byte, n)
+	b2 := make([]byte, n)
+	if l, _ := src.ReadAt(b1, dstSize-n); l != len(b1) {
+		return 0
+	}
+	if l, _ := dst.ReadAt(b2, dstSize-n); l != len(b2) {
+		return 0
+	}
+	if !bytes.Equal(b1, b2) {
+		return 0
+	}
+	return dstSize
+}
+
+type fileHasher interface {
+	Hash(name, algorithm string, pos, size int64) (*socfs.HashResult, error)
+}
+
+// hashChunkSize is the range hashed by a request. Hashing a large file at once can exceed the request timeout.
+var hashChunkSize int64 = 64 * 1024 * 1024
+
+// compareHash compares sha256 checksums of the remote file and the local file for each chunk.
+func compareHash(hasher fileHasher, remotePath, localPath string) (bool, error) {
+	f, err := os.Open(localPath)
 	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	for pos := int64(0); ; pos += hashChunkSize {
+		res, err := hasher.Hash(remotePath, "sha256", pos, hashChunkSize)
+		if err != nil {
+			return false, err
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, io.NewSectionReader(f, pos, hashChunkSize))
+		if err != nil {
+			return false, err
+		}
+		if n != res.Size || hex.EncodeToString(h.Sum(nil)) != res.Hash {
+			return false, nil
+		}
+		if n < hashChunkSize {
+			return true, nil
+		}
+	}
+}
+
+var hashWarning sync.Once
+
+// verifyHash compares the checksum of the remote file and the local file. It is skipped with a warning if the server doesn't support hash.
+func verifyHash(fsys fs.FS, remotePath, localPath string) error {
+	hasher, ok := fsys.(fileHasher)
+	if !ok {
+		hashWarning.Do(func() { log.Println("WARNING: checksum verification is not supported") })
+		return nil
+	}
+	same, err := compareHash(hasher, remotePath, localPath)
+	if errors.Is(err, socfs.ErrUnsupported) {
+		hashWarning.Do(func() { log.Println("WARNING: the server doesn't support hash. checksums are not verified") })
+		return nil
+	} else if err != nil {
 		return err
 	}
-	log.Println("Pull: ", fpath, " (", stat.Size(), "B)")
-	r, err := fsys.Open(fpath)
+	if !same {
+		return errors.New("checksum mismatch: " + remotePath)
+	}
+	return nil
+}
+
+// ctxReader stops reading when the context is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
+
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (w *offsetWriter) Write(b []byte) (int, error) {
+	n, err := w.w.WriteAt(b, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+func parseTransferArgs(cmd string, args []string) (*transferOptions, []string, error) {
+	var opt transferOptions
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	flags.BoolVar(&opt.recursive, "r", false, "recursive")
+	flags.BoolVar(&opt.update, "u", false, "skip files that already match (same size and not older)")
+	flags.BoolVar(&opt.resume, "c", false, "continue interrupted transfers")
+	flags.BoolVar(&opt.verify, "v", false, "verify checksum after transfer")
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if flags.NArg() == 0 {
+		return nil, nil, errors.New("no files")
+	}
+	return &opt, flags.Args(), nil
+}
+
+// extendedStat enables the extended stat of the client to find symlinks in remote directories. The returned func restores the option.
+func extendedStat(fsys fs.FS) func() {
+	client, ok := fsys.(*socfs.FSClient)
+	if !ok {
+		return func() {}
+	}
+	ext := client.ExtendedStat
+	client.ExtendedStat = true
+	return func() { client.ExtendedStat = ext }
+}
+
+// matchFile returns true if dst has the same size and is not older than src.
+func matchFile(src, dst fs.FileInfo) bool {
+	return dst != nil && !dst.IsDir() && src.Size() == dst.Size() && !dst.ModTime().Before(src.ModTime().Truncate(time.Millisecond))
+}
+
+func shellPullFile(ctx context.Context, fsys fs.FS, src, dst string, stat fs.FileInfo, opt *transferOptions, stats *transferStats) error {
+	lstat, _ := os.Stat(dst)
+	if opt.update && lstat != nil && matchFile(stat, lstat) {
+		stats.skipped++
+		return nil
+	}
+	r, err := fsys.Open(src)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
-	w, err := os.Create(filepath.Base(fpath))
+
+	var offset int64
+	if ra, ok := r.(io.ReaderAt); ok && opt.resume && lstat != nil {
+		if lf, err := os.Open(dst); err == nil {
+			offset = resumeOffset(ra, lf, stat.Size(), lstat.Size())
+			lf.Close()
+		}
+	}
+	log.Println("Pull: ", src, " (", stat.Size(), "B)", "offset:", offset)
+	var reader io.Reader = r
+	if offset > 0 {
+		reader = io.NewSectionReader(r.(io.ReaderAt), offset, stat.Size()-offset)
+	}
+	flag := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flag |= os.O_TRUNC
+	}
+	w, err := os.OpenFile(dst, flag, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Seek(offset, io.SeekStart); err == nil {
+		var n int64
+		n, err = io.Copy(w, &ctxReader{ctx: ctx, r: reader})
+		stats.bytes += n
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		return err
 	}
-	defer w.Close()
-	_, err = io.Copy(w, r)
+	if lstat, err := os.Stat(dst); err != nil || lstat.Size() != stat.Size() {
+		return errors.New("size mismatch: " + dst)
+	}
+	if opt.verify || offset > 0 {
+		if err := verifyHash(fsys, src, dst); err != nil {
+			return err
+		}
+	}
+	stats.files++
+	return os.Chtimes(dst, time.Now(), stat.ModTime())
+}
+
+func shellPull(ctx context.Context, fsys fs.FS, cwd string, args []string) error {
+	opt, args, err := parseTransferArgs("pull", args)
+	if err != nil {
+		return err
+	}
+	src := path.Join(cwd, args[0])
+	dst := path.Base(src)
+	if len(args) > 1 {
+		dst = args[1]
+	}
+	if lstat, err := os.Stat(dst); err == nil && lstat.IsDir() {
+		dst = filepath.Join(dst, path.Base(src))
+	}
+	stat, err := fs.Stat(fsys, src)
+	if err != nil {
+		return err
+	}
+	stats := &transferStats{start: time.Now()}
+	if !stat.IsDir() {
+		err = shellPullFile(ctx, fsys, src, dst, stat, opt, stats)
+	} else if !opt.recursive {
+		return errors.New(src + " is a directory (use -r)")
+	} else {
+		defer extendedStat(fsys)()
+		err = fs.WalkDir(fsys, src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			rel := strings.TrimPrefix(strings.TrimPrefix(p, src), "/")
+			localPath := filepath.Join(dst, filepath.FromSlash(rel))
+			if d.IsDir() {
+				return os.MkdirAll(localPath, 0755)
+			}
+			info, err := fs.Stat(fsys, p) // d.Info() doesn't follow symlinks
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				log.Println("Skip symlink to directory:", p)
+				return nil
+			}
+			return shellPullFile(ctx, fsys, p, localPath, info, opt, stats)
+		})
+	}
+	log.Println("Pull:", stats)
 	return err
 }
 
-func shellPushFile(ctx context.Context, fsys *socfs.FSClient, cwd, arg string) error {
-	r, err := os.Open(arg)
+func shellPushFile(ctx context.Context, fsys *socfs.FSClient, src, dst string, stat fs.FileInfo, opt *transferOptions, stats *transferStats) error {
+	rstat, _ := fs.Stat(fsys, dst)
+	if opt.update && rstat != nil && matchFile(stat, rstat) {
+		stats.skipped++
+		return nil
+	}
+	r, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
-	stat, err := r.Stat()
+
+	var offset int64
+	if opt.resume && rstat != nil {
+		if rf, err := fsys.Open(dst); err == nil {
+			if ra, ok := rf.(io.ReaderAt); ok {
+				offset = resumeOffset(r, ra, stat.Size(), rstat.Size())
+			}
+			rf.Close()
+		}
+	}
+	log.Println("Push: ", src, " (", stat.Size(), "B)", "offset:", offset)
+	flag := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flag |= os.O_TRUNC
+	}
+	w, err := fsys.OpenWriter(dst, flag)
 	if err != nil {
 		return err
 	}
-	log.Println("Push: ", arg, " (", stat.Size(), "B)")
+	wa, ok := w.(io.WriterAt)
+	if !ok {
+		w.Close()
+		return errors.New("unsupported operation")
+	}
+	if _, err = r.Seek(offset, io.SeekStart); err == nil {
+		var n int64
+		n, err = io.Copy(&offsetWriter{w: wa, off: offset}, &ctxReader{ctx: ctx, r: r})
+		stats.bytes += n
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if rstat, err := fs.Stat(fsys, dst); err != nil || rstat.Size() != stat.Size() {
+		return errors.New("size mismatch: " + dst)
+	}
+	if opt.verify || offset > 0 {
+		if err := verifyHash(fsys, dst, src); err != nil {
+			return err
+		}
+	}
+	stats.files++
+	return nil
+}
 
-	fpath := path.Join(cwd, filepath.Base(arg))
-	w, err := fsys.Create(fpath)
+func shellPush(ctx context.Context, fsys *socfs.FSClient, cwd string, args []string) error {
+	opt, args, err := parseTransferArgs("push", args)
+	if err != nil {
+		return err
+	}
+	src := args[0]
+	dst := path.Join(cwd, filepath.Base(src))
+	if len(args) > 1 {
+		dst = path.Join(cwd, args[1])
+		if rstat, err := fs.Stat(fsys, dst); err == nil && rstat.IsDir() {
+			dst = path.Join(dst, filepath.Base(src))
+		}
+	}
+	stat, err := os.Stat(src)
 	if err != nil {
 		return err
 	}
-	defer w.Close()
-	_, err = io.Copy(w, r)
+	stats := &transferStats{start: time.Now()}
+	if !stat.IsDir() {
+		err = shellPushFile(ctx, fsys, src, dst, stat, opt, stats)
+	} else if !opt.recursive {
+		return errors.New(src + " is a directory (use -r)")
+	} else {
+		err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			remotePath := path.Join(dst, filepath.ToSlash(rel))
+			if d.IsDir() {
+				if rstat, err := fs.Stat(fsys, remotePath); err == nil && rstat.IsDir() {
+					return nil
+				}
+				return fsys.Mkdir(remotePath, fs.ModePerm)
+			}
+			info, err := os.Stat(p) // d.Info() doesn't follow symlinks
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				log.Println("Skip symlink to directory:", p)
+				return nil
+			}
+			return shellPushFile(ctx, fsys, p, remotePath, info, opt, stats)
+		})
+	}
+	log.Println("Push:", stats)
 	return err
 }
 
-func shellExecCmd(ctx context.Context, client *socfs.FSClient, cwd, cmd, arg string) error {
+func shellRemove(ctx context.Context, client *socfs.FSClient, cwd string, args []string) error {
+	flags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	recursive := flags.Bool("r", false, "remove directories recursively")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	for _, arg := range flags.Args() {
+		remove := client.Remove
+		if *recursive {
+			remove = client.RemoveAll
+		}
+		if err := remove(path.Join(cwd, arg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shellCopy copies or moves files on the remote side. SRC is placed in DST if DST is a directory.
+func shellCopy(ctx context.Context, client *socfs.FSClient, cwd, cmd string, args []string) error {
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	overwrite := flags.Bool("f", false, "overwrite existing files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: " + cmd + " [-f] SRC DST")
+	}
+	src, dst := path.Join(cwd, flags.Arg(0)), path.Join(cwd, flags.Arg(1))
+	if stat, err := client.Stat(dst); err == nil && stat.IsDir() {
+		dst = path.Join(dst, path.Base(src))
+	}
+	if cmd == "mv" {
+		return client.Move(src, dst, *overwrite)
+	}
+	return client.Copy(src, dst, *overwrite)
+}
+
+func shellExecCmd(ctx context.Context, client *socfs.FSClient, cwd, cmd string, args []string) error {
+	arg := ""
+	if len(args) > 0 {
+		arg = args[0]
+	}
 	switch cmd {
 	case "":
 		return nil
@@ -120,17 +546,21 @@ func shellExecCmd(ctx context.Context, client *socfs.FSClient, cwd, cmd, arg str
 	case "ls":
 		return shellListFiles(ctx, client, cwd, arg)
 	case "pull":
-		return shellPullFile(ctx, client, cwd, arg)
+		return shellPull(ctx, client, cwd, args)
 	case "cat":
 		return shellCat(ctx, client, cwd, arg)
 	case "push":
-		return shellPushFile(ctx, client, cwd, arg)
+		return shellPush(ctx, client, cwd, args)
+	case "sync":
+		return shellSync(ctx, client, cwd, args)
 	case "rm":
-		return client.Remove(path.Join(cwd, arg))
+		return shellRemove(ctx, client, cwd, args)
+	case "cp", "mv":
+		return shellCopy(ctx, client, cwd, cmd, args)
 	case "mkdir":
 		return client.Mkdir(path.Join(cwd, arg), fs.ModePerm)
 	case "?", "help":
-		fmt.Println("Commands: exit, pwd, cd PATH, ls PATH, pull FILE, push FILE, cat FILE, rm FILE")
+		fmt.Println("Commands: exit, pwd, cd PATH, ls PATH, pull [-r] [-u] [-c] [-v] PATH [LOCAL], push [-r] [-u] [-c] [-v] LOCAL [PATH], sync [-mode pull|push|both] [-dry-run] [-delete] [-hash] LOCAL PATH, cat FILE, rm [-r] PATH, cp [-f] SRC DST, mv [-f] SRC DST")
 		return nil
 	default:
 		return errors.New("No such command: " + cmd)
@@ -138,12 +568,21 @@ func shellExecCmd(ctx context.Context, client *socfs.FSClient, cwd, cmd, arg str
 }
 
 func ShellExec(ctx context.Context, options *ConnectOptions, cmd, arg string) error {
+	var args []string
+	if arg != "" {
+		args = []string{arg}
+	}
+	return ShellExecArgs(ctx, options, cmd, args)
+}
+
+// ShellExecArgs executes the command with options and arguments. e.g. ["-r", "dir", "local"]
+func ShellExecArgs(ctx context.Context, options *ConnectOptions, cmd string, args []string) error {
 	rtcConn, client, err := GetClinet(ctx, options, &ClientOptions{MaxRedirect: 3})
 	if err != nil {
 		return err
 	}
 	defer rtcConn.Close()
-	return shellExecCmd(ctx, client, "/", cmd, arg)
+	return shellExecCmd(ctx, client, "/", cmd, args)
 }
 
 func StartShell(ctx context.Context, options *ConnectOptions) error {
@@ -154,23 +593,21 @@ func StartShell(ctx context.Context, options *ConnectOptions) error {
 	defer rtcConn.Close()
 
 	cwd := "/"
-	shellExecCmd(ctx, client, cwd, "help", "")
+	shellExecCmd(ctx, client, cwd, "help", nil)
 	s := bufio.NewScanner(os.Stdin)
 	for s.Scan() {
 		cmd := strings.Fields(s.Text())
 		if len(cmd) == 0 {
 			continue
 		}
-		arg := ""
-		if len(cmd) > 1 {
-			arg = cmd[1]
-		}
 		if cmd[0] == "exit" {
 			return nil
 		} else if cmd[0] == "cd" {
-			cwd = path.Join(cwd, arg)
+			if len(cmd) > 1 {
+				cwd = path.Join(cwd, cmd[1])
+			}
 		} else {
-			err := shellExecCmd(ctx, client, cwd, cmd[0], arg)
+			err := shellExecCmd(ctx, client, cwd, cmd[0], cmd[1:])
 			if err != nil {
 				fmt.Println("ERROR: ", err)
 			}
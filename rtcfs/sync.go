@@ -0,0 +1,422 @@
+package rtcfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/binzume/webrtcfs/socfs"
+)
+
+type SyncOptions struct {
+	// "pull": remote to local, "push": local to remote, "both": newer file wins
+	Mode   string
+	DryRun bool
+	// Delete files which don't exist in the source (one-way only)
+	Delete bool
+	// Compare checksums instead of modtimes if sizes are same
+	Hash bool
+	// Copy permission bits of remote files to local files (pull only)
+	Perms bool
+}
+
+type syncTree map[string]fs.FileInfo
+
+// listTree returns files and directories under root. keys are slash separated relative paths.
+func listTree(fsys fs.FS, root string) (syncTree, error) {
+	tree := syncTree{}
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	if root == "." {
+		prefix = ""
+	}
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			// sync the linked file. links to directories are skipped to avoid loops.
+			if info, err = fs.Stat(fsys, p); err != nil || info.IsDir() {
+				log.Println("skip symlink:", p)
+				return nil
+			}
+		}
+		tree[strings.TrimPrefix(p, prefix)] = info
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return tree, nil
+	}
+	return tree, err
+}
+
+func (t syncTree) sortedKeys(reverse bool) []string {
+	var keys []string
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	}
+	return keys
+}
+
+// sameHash returns true if the checksums are same. false if the server doesn't support hash.
+func sameHash(client *socfs.FSClient, remotePath, localPath string) bool {
+	same, err := compareHash(client, remotePath, localPath)
+	return err == nil && same
+}
+
+// syncStateFile keeps file states after the last two-way sync to detect conflicts.
+const syncStateFile = ".rtcfs-sync.json"
+
+type syncFileState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// changed returns true if the file is changed since the last sync. Local modtimes are set to remote ones by sync.
+func (st *syncFileState) changed(info fs.FileInfo) bool {
+	return info.Size() != st.Size || !sameModTime(info.ModTime(), st.ModTime)
+}
+
+func sameModTime(t1, t2 time.Time) bool {
+	return t1.Truncate(time.Millisecond).Equal(t2.Truncate(time.Millisecond))
+}
+
+func loadSyncState(localDir string) map[string]*syncFileState {
+	state := map[string]*syncFileState{}
+	if data, err := os.ReadFile(filepath.Join(localDir, syncStateFile)); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	return state
+}
+
+func saveSyncState(localDir string, state map[string]*syncFileState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(localDir, syncStateFile), data, 0644)
+}
+
+type syncer struct {
+	ctx        context.Context
+	client     *socfs.FSClient
+	localDir   string
+	remoteDir  string
+	opt        *SyncOptions
+	stats      *transferStats
+	remoteTree syncTree
+	localTree  syncTree
+	lastState  map[string]*syncFileState
+	state      map[string]*syncFileState
+	conflicts  map[string]bool
+}
+
+// record saves the state of the synced file. (two-way only)
+func (s *syncer) record(rel string, remote fs.FileInfo) {
+	if s.opt.Mode == "both" && !remote.IsDir() {
+		s.state[rel] = &syncFileState{Size: remote.Size(), ModTime: remote.ModTime()}
+	}
+}
+
+func (s *syncer) conflict(rel string) {
+	log.Println("conflict:", rel)
+	s.conflicts[rel] = true
+	if st, ok := s.lastState[rel]; ok {
+		s.state[rel] = st
+	}
+}
+
+// inConflict returns true if rel or its parent directory is in conflict.
+// A file is in conflict with a directory in the other side.
+func (s *syncer) inConflict(rel string) bool {
+	for ; rel != "."; rel = path.Dir(rel) {
+		if s.conflicts[rel] {
+			return true
+		}
+	}
+	return false
+}
+
+// typeConflict returns true if rel is a file in one side and a directory in the other side.
+func (s *syncer) typeConflict(rel string) bool {
+	local, remote := s.localTree[rel], s.remoteTree[rel]
+	if local != nil && remote != nil && local.IsDir() != remote.IsDir() {
+		s.conflict(rel)
+	}
+	if !s.inConflict(rel) {
+		return false
+	}
+	if st, ok := s.lastState[rel]; ok {
+		s.state[rel] = st
+	}
+	return true
+}
+
+func (s *syncer) localPath(rel string) string {
+	return filepath.Join(s.localDir, filepath.FromSlash(rel))
+}
+
+func (s *syncer) remotePath(rel string) string {
+	return path.Join(s.remoteDir, rel)
+}
+
+func (s *syncer) skip(info fs.FileInfo) {
+	if !info.IsDir() {
+		s.stats.skipped++
+	}
+}
+
+func (s *syncer) pull(rel string) error {
+	log.Println("pull:", rel)
+	if s.opt.DryRun {
+		return nil
+	}
+	var err error
+	if s.remoteTree[rel].IsDir() {
+		err = os.MkdirAll(s.localPath(rel), 0755)
+	} else {
+		err = shellPullFile(s.ctx, s.client, s.remotePath(rel), s.localPath(rel), s.remoteTree[rel], &transferOptions{}, s.stats)
+	}
+	if err != nil {
+		return err
+	}
+	s.record(rel, s.remoteTree[rel])
+	if !s.opt.Perms {
+		return nil
+	}
+	return copyPerm(s.remoteTree[rel], s.localPath(rel))
+}
+
+// copyPerm sets permission bits if the remote entry has the extended stat.
+func copyPerm(remote fs.FileInfo, localPath string) error {
+	if ent, ok := remote.Sys().(*socfs.FileEntry); !ok || ent.FileMode == 0 {
+		return nil
+	}
+	return os.Chmod(localPath, remote.Mode().Perm())
+}
+
+func (s *syncer) push(rel string) error {
+	log.Println("push:", rel)
+	if s.opt.DryRun {
+		return nil
+	}
+	if s.localTree[rel].IsDir() {
+		if stat, err := fs.Stat(s.client, s.remotePath(rel)); err == nil && stat.IsDir() {
+			return nil
+		}
+		return s.client.Mkdir(s.remotePath(rel), fs.ModePerm)
+	}
+	err := shellPushFile(s.ctx, s.client, s.localPath(rel), s.remotePath(rel), s.localTree[rel], &transferOptions{}, s.stats)
+	if err != nil || s.opt.Mode != "both" {
+		return err
+	}
+	// Remote modtime can't be changed.
+	stat, err := s.client.Stat(s.remotePath(rel))
+	if err != nil {
+		return err
+	}
+	s.record(rel, stat)
+	return os.Chtimes(s.localPath(rel), time.Now(), stat.ModTime())
+}
+
+// upToDate returns true if dst doesn't need to be updated from src.
+func (s *syncer) upToDate(src, dst fs.FileInfo, rel string) bool {
+	if dst == nil || src.IsDir() || dst.IsDir() {
+		return dst != nil && src.IsDir() == dst.IsDir()
+	}
+	if src.Size() != dst.Size() {
+		return false
+	}
+	if s.opt.Hash {
+		if !sameHash(s.client, s.remotePath(rel), s.localPath(rel)) {
+			return false
+		}
+		if !s.opt.DryRun && s.opt.Mode != "push" {
+			os.Chtimes(s.localPath(rel), time.Now(), s.remoteTree[rel].ModTime())
+		}
+		return true
+	}
+	if s.opt.Mode == "push" {
+		// Remote modtime can't be changed. Use -hash to detect changes of remote files.
+		return !dst.ModTime().Before(src.ModTime().Truncate(time.Millisecond))
+	}
+	// Local modtime is set to the remote modtime after transfer.
+	return sameModTime(src.ModTime(), dst.ModTime())
+}
+
+func (s *syncer) sync() error {
+	switch s.opt.Mode {
+	case "pull":
+		for _, rel := range s.remoteTree.sortedKeys(false) {
+			if s.ctx.Err() != nil {
+				return s.ctx.Err()
+			}
+			if s.typeConflict(rel) {
+				continue
+			} else if s.upToDate(s.remoteTree[rel], s.localTree[rel], rel) {
+				s.skip(s.remoteTree[rel])
+			} else if err := s.pull(rel); err != nil {
+				return err
+			}
+		}
+		if s.opt.Delete {
+			for _, rel := range s.localTree.sortedKeys(true) {
+				if _, ok := s.remoteTree[rel]; !ok && !s.inConflict(rel) {
+					log.Println("delete local:", rel)
+					if !s.opt.DryRun {
+						if err := os.Remove(s.localPath(rel)); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	case "push":
+		for _, rel := range s.localTree.sortedKeys(false) {
+			if s.ctx.Err() != nil {
+				return s.ctx.Err()
+			}
+			if s.typeConflict(rel) {
+				continue
+			} else if s.upToDate(s.localTree[rel], s.remoteTree[rel], rel) {
+				s.skip(s.localTree[rel])
+			} else if err := s.push(rel); err != nil {
+				return err
+			}
+		}
+		if s.opt.Delete {
+			for _, rel := range s.remoteTree.sortedKeys(true) {
+				if _, ok := s.localTree[rel]; !ok && !s.inConflict(rel) {
+					log.Println("delete remote:", rel)
+					if !s.opt.DryRun {
+						if err := s.client.Remove(s.remotePath(rel)); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	case "both":
+		if s.opt.Delete {
+			return errors.New("delete is not supported in two-way sync")
+		}
+		all := syncTree{}
+		for k, v := range s.remoteTree {
+			all[k] = v
+		}
+		for k, v := range s.localTree {
+			all[k] = v
+		}
+		for _, rel := range all.sortedKeys(false) {
+			if s.ctx.Err() != nil {
+				return s.ctx.Err()
+			}
+			local, remote := s.localTree[rel], s.remoteTree[rel]
+			var err error
+			if s.typeConflict(rel) {
+				continue
+			} else if local == nil {
+				err = s.pull(rel)
+			} else if remote == nil {
+				err = s.push(rel)
+			} else if s.upToDate(local, remote, rel) {
+				s.skip(local)
+				s.record(rel, remote)
+			} else if last, ok := s.lastState[rel]; ok {
+				if last.changed(local) && last.changed(remote) {
+					s.conflict(rel)
+				} else if last.changed(local) {
+					err = s.push(rel)
+				} else {
+					err = s.pull(rel)
+				}
+			} else if local.ModTime().After(remote.ModTime()) {
+				err = s.push(rel)
+			} else {
+				err = s.pull(rel)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("unknown sync mode: " + s.opt.Mode)
+	}
+	return nil
+}
+
+// Sync copies differences between the local directory and the remote directory.
+func Sync(ctx context.Context, client *socfs.FSClient, localDir, remoteDir string, opt *SyncOptions) error {
+	s := &syncer{ctx: ctx, client: client, localDir: localDir, remoteDir: remoteDir, opt: opt, stats: &transferStats{start: time.Now()}, conflicts: map[string]bool{}}
+	defer extendedStat(client)()
+	var err error
+	if s.localTree, err = listTree(os.DirFS(localDir), "."); err != nil {
+		return err
+	}
+	delete(s.localTree, syncStateFile)
+	if opt.Mode == "both" {
+		s.lastState = loadSyncState(localDir)
+		s.state = map[string]*syncFileState{}
+	}
+	if s.remoteTree, err = listTree(client, remoteDir); err != nil {
+		return err
+	}
+	if !opt.DryRun && opt.Mode != "push" {
+		if err := os.MkdirAll(localDir, 0755); err != nil {
+			return err
+		}
+	}
+	if !opt.DryRun && opt.Mode != "pull" {
+		if stat, err := fs.Stat(client, remoteDir); err != nil || !stat.IsDir() {
+			if err := client.Mkdir(remoteDir, fs.ModePerm); err != nil {
+				return err
+			}
+		}
+	}
+	err = s.sync()
+	log.Println("Sync:", s.stats)
+	if err == nil && opt.Mode == "both" && !opt.DryRun {
+		err = saveSyncState(localDir, s.state)
+	}
+	if err == nil && len(s.conflicts) > 0 {
+		err = fmt.Errorf("%d conflicts", len(s.conflicts))
+	}
+	return err
+}
+
+func shellSync(ctx context.Context, client *socfs.FSClient, cwd string, args []string) error {
+	var opt SyncOptions
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	flags.StringVar(&opt.Mode, "mode", "pull", "pull, push or both")
+	flags.BoolVar(&opt.DryRun, "dry-run", false, "show changes without copying")
+	flags.BoolVar(&opt.Delete, "delete", false, "delete files which don't exist in the source")
+	flags.BoolVar(&opt.Hash, "hash", false, "compare checksums")
+	flags.BoolVar(&opt.Perms, "perms", false, "copy permission bits of remote files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("usage: sync [-mode pull|push|both] [-dry-run] [-delete] [-hash] [-perms] LOCAL REMOTE")
+	}
+	return Sync(ctx, client, flags.Arg(0), path.Join(cwd, flags.Arg(1)), &opt)
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/binzume/webrtcfs/ayame"
 	"github.com/pion/dtls/v2/pkg/crypto/fingerprint"
@@ -15,6 +17,18 @@ import (
 type RTCConn struct {
 	ayameConn *ayame.AyameConn
 	PC        *webrtc.PeerConnection
+
+	// ICE restart is attempted when the connection is lost.
+	ICERestartTimeout time.Duration
+	MaxICERestarts    int
+	// OnSignalingMessage is called when a signaling message is received from the peer.
+	OnSignalingMessage func(msg *ayame.SignalingMessage)
+
+	lock           sync.Mutex
+	restartTimer   *time.Timer
+	restarts       int
+	restartOffered bool // an offer was received while restarting
+	err            error
 }
 
 type DataChannelHandler interface {
@@ -60,12 +74,19 @@ func initDataChannelHandler(d *webrtc.DataChannel, handler DataChannelHandler) {
 }
 
 func NewRTCConn(signalingUrl, roomID, signalingKey string) (*RTCConn, error) {
-	conn, err := ayame.Dial(signalingUrl, roomID, signalingKey)
+	return newRTCConn(&ConnectOptions{SignalingURL: signalingUrl, SignalingKey: signalingKey}, roomID)
+}
+
+func newRTCConn(options *ConnectOptions, roomID string) (*RTCConn, error) {
+	conn, err := ayame.Dial(options.SignalingURL, roomID, options.SignalingKey)
 	if err != nil {
 		return nil, err
 	}
 
 	rtcConfig := webrtc.Configuration{}
+	if options.Certificate != nil {
+		rtcConfig.Certificates = []webrtc.Certificate{*options.Certificate}
+	}
 	for _, iceServer := range conn.AuthResult.IceServers {
 		rtcConfig.ICEServers = append(rtcConfig.ICEServers, webrtc.ICEServer{
 			URLs:       iceServer.URLs,
@@ -79,7 +100,7 @@ func NewRTCConn(signalingUrl, roomID, signalingKey string) (*RTCConn, error) {
 		conn.Close()
 		return nil, err
 	}
-	return &RTCConn{ayameConn: conn, PC: peerConnection}, nil
+	return &RTCConn{ayameConn: conn, PC: peerConnection, ICERestartTimeout: 20 * time.Second, MaxICERestarts: 3}, nil
 }
 
 func (c *RTCConn) IsExistRoom() bool {
@@ -88,12 +109,7 @@ func (c *RTCConn) IsExistRoom() bool {
 
 // AddTrack/CreateDataChannel shoudl be done before Start()
 func (c *RTCConn) Start(dataChannles []DataChannelHandler) {
-	c.PC.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
-		log.Printf("Peer Connection State has changed: %s\n", s.String())
-		if s == webrtc.PeerConnectionStateFailed || s == webrtc.PeerConnectionStateDisconnected || s == webrtc.PeerConnectionStateClosed {
-			c.ayameConn.Close()
-		}
-	})
+	c.PC.OnConnectionStateChange(c.onConnectionStateChange)
 
 	// Trickle ICE
 	c.PC.OnICECandidate(func(ic *webrtc.ICECandidate) {
@@ -122,46 +138,144 @@ func (c *RTCConn) Start(dataChannles []DataChannelHandler) {
 	}
 
 	if c.ayameConn.AuthResult.IsExistClient {
-		offer, _ := c.PC.CreateOffer(nil)
-		if err := c.PC.SetLocalDescription(offer); err != nil {
-			log.Fatal(err)
+		offer, err := c.PC.CreateOffer(nil)
+		if err == nil {
+			err = c.PC.SetLocalDescription(offer)
+		}
+		if err != nil {
+			c.fail(err)
+			return
 		}
 		c.ayameConn.Offer(offer.SDP)
 	}
 	go func() {
 		for msg := range c.ayameConn.Msg {
-			switch msg.Type {
-			case "candidate":
-				cand := webrtc.ICECandidateInit{Candidate: msg.ICE.Candidate, SDPMid: msg.ICE.SdpMid, SDPMLineIndex: msg.ICE.SdpMLineIndex}
-				if err := c.PC.AddICECandidate(cand); err != nil {
-					log.Fatal(err)
-				}
-			case "offer":
-				desc := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: msg.SDP}
-				if err := c.PC.SetRemoteDescription(desc); err != nil {
-					log.Fatal(err)
-				}
-
-				answer, err := c.PC.CreateAnswer(nil)
-				if err != nil {
-					log.Fatal(err)
-				}
-				if err := c.PC.SetLocalDescription(answer); err != nil {
-					log.Fatal(err)
-				}
-				c.ayameConn.Answer(answer.SDP)
-			case "answer":
-				desc := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: msg.SDP}
-				if err := c.PC.SetRemoteDescription(desc); err != nil {
-					log.Println(err)
-				}
-			default:
-				log.Println("Unknown message:", msg.Type)
+			if c.OnSignalingMessage != nil {
+				c.OnSignalingMessage(msg)
+			}
+			if err := c.handleSignalingMessage(msg); err != nil {
+				c.fail(err)
+				return
 			}
 		}
 	}()
 }
 
+func (c *RTCConn) onConnectionStateChange(s webrtc.PeerConnectionState) {
+	log.Printf("Peer Connection State has changed: %s\n", s.String())
+	if s == webrtc.PeerConnectionStateConnected {
+		c.lock.Lock()
+		if c.restartTimer != nil {
+			c.restartTimer.Stop()
+			c.restartTimer = nil
+		}
+		c.restarts = 0
+		c.lock.Unlock()
+	} else if s == webrtc.PeerConnectionStateFailed || s == webrtc.PeerConnectionStateDisconnected {
+		c.restartICE()
+	} else if s == webrtc.PeerConnectionStateClosed {
+		c.ayameConn.Close()
+	}
+}
+
+func (c *RTCConn) handleSignalingMessage(msg *ayame.SignalingMessage) error {
+	switch msg.Type {
+	case "candidate":
+		if msg.ICE == nil {
+			return errors.New("invalid candidate message")
+		}
+		cand := webrtc.ICECandidateInit{Candidate: msg.ICE.Candidate, SDPMid: msg.ICE.SdpMid, SDPMLineIndex: msg.ICE.SdpMLineIndex}
+		return c.PC.AddICECandidate(cand)
+	case "offer":
+		c.lock.Lock()
+		c.restartOffered = c.restartTimer != nil
+		c.lock.Unlock()
+		desc := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: msg.SDP}
+		if err := c.PC.SetRemoteDescription(desc); err != nil {
+			return err
+		}
+
+		answer, err := c.PC.CreateAnswer(nil)
+		if err != nil {
+			return err
+		}
+		if err := c.PC.SetLocalDescription(answer); err != nil {
+			return err
+		}
+		return c.ayameConn.Answer(answer.SDP)
+	case "answer":
+		desc := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: msg.SDP}
+		return c.PC.SetRemoteDescription(desc)
+	default:
+		log.Println("Unknown message:", msg.Type)
+	}
+	return nil
+}
+
+// fail closes the connection. The error is returned by Wait().
+func (c *RTCConn) fail(err error) {
+	log.Println("connection error:", err)
+	c.lock.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.lock.Unlock()
+	c.Close()
+}
+
+// restartICE sends an offer with ICE restart over the signaling channel.
+// The answerer waits for the offerer's restart and sends its own offer if none arrives.
+func (c *RTCConn) restartICE() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.restartTimer != nil {
+		return // restarting
+	}
+	if c.restarts >= c.MaxICERestarts {
+		c.ayameConn.Close()
+		return
+	}
+	select {
+	case <-c.ayameConn.Done():
+		return
+	default:
+	}
+	c.restarts++
+	c.restartOffered = false
+	c.restartTimer = time.AfterFunc(c.ICERestartTimeout, func() {
+		if c.PC.ConnectionState() != webrtc.PeerConnectionStateConnected {
+			log.Println("ICE restart timeout")
+			c.ayameConn.Close()
+		}
+	})
+	if !c.ayameConn.AuthResult.IsExistClient {
+		time.AfterFunc(c.ICERestartTimeout/4, func() {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			if c.restartTimer != nil && !c.restartOffered && c.PC.SignalingState() == webrtc.SignalingStateStable {
+				c.sendRestartOffer()
+			}
+		})
+		return
+	}
+	c.sendRestartOffer()
+}
+
+// sendRestartOffer creates an offer with ICE restart. (locked)
+func (c *RTCConn) sendRestartOffer() {
+	log.Println("ICE restart")
+	offer, err := c.PC.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		log.Println("ICE restart error:", err)
+		return
+	}
+	if err := c.PC.SetLocalDescription(offer); err != nil {
+		log.Println("ICE restart error:", err)
+		return
+	}
+	c.ayameConn.Offer(offer.SDP)
+}
+
 func (c *RTCConn) LocalCertificateFingerprint() (string, error) {
 	localPram, err := c.PC.SCTP().Transport().GetLocalParameters()
 	if err != nil {
@@ -199,6 +313,11 @@ func (c *RTCConn) RemoteCertificateHash(algoname string) (string, error) {
 }
 
 func (c *RTCConn) Close() error {
+	c.lock.Lock()
+	if c.restartTimer != nil {
+		c.restartTimer.Stop()
+	}
+	c.lock.Unlock()
 	c.ayameConn.Close()
 	return c.PC.Close()
 }
@@ -206,7 +325,12 @@ func (c *RTCConn) Close() error {
 func (c *RTCConn) Wait(ctx context.Context) error {
 	select {
 	case <-c.ayameConn.Done():
-		return c.ayameConn.LastError
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if c.err != nil {
+			return c.err
+		}
+		return c.ayameConn.Err()
 	case <-ctx.Done():
 		return ctx.Err()
 	}
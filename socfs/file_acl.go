@@ -0,0 +1,78 @@
+package socfs
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ACL restricts accessible paths and write permission for a peer.
+type ACL struct {
+	// Allowed path prefixes. Empty means all paths.
+	Paths    []string
+	Writable bool
+}
+
+var writeOps = map[string]bool{"write": true, "truncate": true, "mkdir": true, "rename": true, "remove": true, "symlink": true, "link": true,
+	"removeAll": true, "copy": true, "move": true}
+
+func (a *ACL) normalizedPaths() []string {
+	var paths []string
+	for _, p := range a.Paths {
+		paths = append(paths, fixPath(path.Clean("/"+p)))
+	}
+	return paths
+}
+
+// allowed returns true if name is under the allowed paths.
+func (a *ACL) allowed(name string) bool {
+	if a == nil || len(a.Paths) == 0 {
+		return true
+	}
+	name = fixPath(path.Clean("/" + name))
+	for _, p := range a.normalizedPaths() {
+		if p == "." || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// visible returns true if name is allowed or a parent directory of the allowed paths.
+func (a *ACL) visible(name string) bool {
+	if a.allowed(name) {
+		return true
+	}
+	name = fixPath(path.Clean("/" + name))
+	for _, p := range a.normalizedPaths() {
+		if name == "." || strings.HasPrefix(p, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *ACL) check(op *FileOperationRequest) error {
+	if a == nil {
+		return nil
+	}
+	if (writeOps[op.Op] || op.Op == "open" && op.Options["mode"] == "w") && !a.Writable {
+		return fs.ErrPermission
+	}
+	if op.Op == "stat" || op.Op == "files" || op.Op == "opendir" || op.Op == "search" {
+		if !a.visible(op.Path) {
+			return fs.ErrPermission
+		}
+	} else if op.Handle == 0 && !a.allowed(strings.TrimSuffix(op.Path, ThumbnailSuffix)) {
+		return fs.ErrPermission
+	}
+	if op.Path2 != "" && !a.allowed(op.Path2) {
+		return fs.ErrPermission
+	}
+	if op.Op == "symlink" {
+		if target, ok := symlinkTarget(op.Path, op.Options["target"]); !ok || !a.allowed(target) {
+			return fs.ErrPermission
+		}
+	}
+	return nil
+}
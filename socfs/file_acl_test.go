@@ -0,0 +1,70 @@
+package socfs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFileHandler_acl(t *testing.T) {
+	fsys := fstest.MapFS{
+		"public/a.txt":  &fstest.MapFile{Data: []byte("a"), Mode: 0664},
+		"private/b.txt": &fstest.MapFile{Data: []byte("b")},
+	}
+	server := NewFSServer(&fakeWritableFs{FS: fsys}, 1)
+	server.SetACL(&ACL{Paths: []string{"/public"}})
+
+	ret, err := server.HanldeFileOp(&FileOperationRequest{Op: "files", Path: "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := ret.([]*FileEntry); len(files) != 1 || files[0].Name() != "public" {
+		t.Error("invalid files", files)
+	}
+	_, err = server.HanldeFileOp(&FileOperationRequest{Op: "read", Path: "/public/a.txt", Len: 10})
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = server.HanldeFileOp(&FileOperationRequest{Op: "read", Path: "/private/b.txt", Len: 10})
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Error("should be permission error", err)
+	}
+	_, err = server.HanldeFileOp(&FileOperationRequest{Op: "stat", Path: "/public/../private/b.txt"})
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Error("should be permission error", err)
+	}
+	_, err = server.HanldeFileOp(&FileOperationRequest{Op: "remove", Path: "/public/a.txt"})
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Error("should be permission error", err)
+	}
+	if server.FSCaps().Remove {
+		t.Error("remove should be disabled")
+	}
+	ret, err = server.HanldeFileOp(&FileOperationRequest{Op: "stat", Path: "/public/a.txt", Options: map[string]string{"stat": "extended"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := ret.(*FileEntry).Mode(); mode != 0444 {
+		t.Error("write bits should be cleared: ", mode)
+	}
+
+	server.SetACL(&ACL{Paths: []string{"/public"}, Writable: true})
+	_, err = server.HanldeFileOp(&FileOperationRequest{Op: "remove", Path: "/public/a.txt"})
+	if err != nil {
+		t.Error(err)
+	}
+	_, err = server.HanldeFileOp(&FileOperationRequest{Op: "rename", Path: "/public/a.txt", Path2: "/private/a.txt"})
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Error("should be permission error", err)
+	}
+	for _, target := range []string{"../private/b.txt", "/private/b.txt", "../../etc/passwd", "d/..", "d/../../private"} {
+		err = server.acl.Load().check(&FileOperationRequest{Op: "symlink", Path: "/public/link", Options: map[string]string{"target": target}})
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Error("symlink should be permission error", target, err)
+		}
+	}
+	if err := server.acl.Load().check(&FileOperationRequest{Op: "symlink", Path: "/public/link", Options: map[string]string{"target": "a.txt"}}); err != nil {
+		t.Error("symlink in the allowed path: ", err)
+	}
+}
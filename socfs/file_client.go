@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,6 +27,9 @@ type statCacheE struct {
 }
 
 func (c *statCache) set(path string, value fs.FileInfo) {
+	if value != nil && value.Mode()&fs.ModeSymlink != 0 {
+		return // Stat() follows symlinks
+	}
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	c.stats[path] = &statCacheE{value: value, time: time.Now()}
@@ -67,18 +71,19 @@ type filesCache struct {
 type filesCacheE struct {
 	value []fs.DirEntry
 	limit int
+	ext   bool // extended stat
 	time  time.Time
 }
 
-func (c *filesCache) set(path string, value []fs.DirEntry, limit int) {
+func (c *filesCache) set(path string, value []fs.DirEntry, limit int, ext bool) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.values[path] = &filesCacheE{value: value, limit: limit, time: time.Now()}
+	c.values[path] = &filesCacheE{value: value, limit: limit, ext: ext, time: time.Now()}
 }
-func (c *filesCache) get(path string) ([]fs.DirEntry, int, bool) {
+func (c *filesCache) get(path string, ext bool) ([]fs.DirEntry, int, bool) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	if s, ok := c.values[path]; ok {
+	if s, ok := c.values[path]; ok && s.ext == ext {
 		if s.time.Add(filesCacheExpireTime).After(time.Now()) {
 			return s.value, s.limit, true
 		}
@@ -107,64 +112,218 @@ type FSClient struct {
 	sendFunc    func(req *FileOperationRequest) error
 	reqCount    uint32
 	wait        map[uint32]chan *FileOperationResult
+	streams     map[uint32]chan *FileOperationResult
 	locker      sync.Mutex
 	MaxReadSize int
 	Timeout     time.Duration
-	statCache   statCache
-	filesCache  filesCache
+	// Timeout for copy, move and removeAll which may process a whole directory tree.
+	LongTimeout time.Duration
+	// Number of unacknowledged chunks in a read stream
+	StreamWindow int
+	// Number of outstanding read requests ahead of the current position
+	ReadAhead int
+	// Number of outstanding write requests
+	WriteWindow int
+	// Request unix mode, owner and timestamps if the server supports it.
+	ExtendedStat bool
+	// Reconnect is called to restore the connection. idempotent operations are retried up to MaxRetry times.
+	Reconnect func() error
+	MaxRetry  int
+
+	generation    uint32
+	reconnectLock sync.Mutex
+
+	statCache  statCache
+	filesCache filesCache
+	caps       atomic.Pointer[FSCapability]
 }
 
 func NewFSClient(sendFunc func(req *FileOperationRequest) error) *FSClient {
 	return &FSClient{
 		sendFunc: sendFunc,
-		wait:     map[uint32]chan *FileOperationResult{}, MaxReadSize: 65000, Timeout: 30 * time.Second,
+		wait:     map[uint32]chan *FileOperationResult{}, MaxReadSize: 65000, Timeout: 30 * time.Second, LongTimeout: 30 * time.Minute, ReadAhead: 4, WriteWindow: 8, MaxRetry: 3,
+		streams:    map[uint32]chan *FileOperationResult{},
 		statCache:  statCache{stats: map[string]*statCacheE{}},
 		filesCache: filesCache{values: map[string]*filesCacheE{}},
 	}
 }
 
+// SetCapability sets server capabilities to enable protocol extensions.
+func (c *FSClient) SetCapability(caps *FSCapability) {
+	copied := *caps
+	c.caps.Store(&copied)
+}
+
+func (c *FSClient) capability() *FSCapability {
+	if caps := c.caps.Load(); caps != nil {
+		return caps
+	}
+	return &FSCapability{}
+}
+
+// Operations which can be retried after reconnecting
+var idempotentOps = map[string]bool{"stat": true, "files": true, "read": true, "hash": true, "readlink": true}
+
+var longOps = map[string]bool{"copy": true, "move": true, "removeAll": true}
+
+type connectionError struct {
+	err error
+}
+
+func (e *connectionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *connectionError) Unwrap() error {
+	return e.err
+}
+
+// Timeouts are not connection errors. Slow operations should not abort other requests by reconnecting.
+var errTimeout = os.ErrDeadlineExceeded
+var errDisconnected = &connectionError{os.ErrClosed}
+
+func isConnectionError(err error) bool {
+	var cerr *connectionError
+	return errors.As(err, &cerr)
+}
+
+// SetSendFunc replaces the transport and returns its generation. (for reconnecting)
+func (c *FSClient) SetSendFunc(sendFunc func(req *FileOperationRequest) error) uint32 {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.sendFunc = sendFunc
+	c.generation++
+	return c.generation
+}
+
+// AbortConnection aborts all requests if the transport of the generation is still used.
+func (c *FSClient) AbortConnection(generation uint32) {
+	c.locker.Lock()
+	current := c.generation
+	c.locker.Unlock()
+	if current == generation {
+		c.Abort()
+	}
+}
+
+func (c *FSClient) currentGeneration() uint32 {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.generation
+}
+
+func (c *FSClient) reconnect(generation uint32) error {
+	c.reconnectLock.Lock()
+	defer c.reconnectLock.Unlock()
+	if c.currentGeneration() != generation {
+		return nil // already reconnected
+	}
+	if err := c.Reconnect(); err != nil {
+		return err
+	}
+	if c.currentGeneration() == generation {
+		return errDisconnected
+	}
+	return nil
+}
+
 func (c *FSClient) request(req *FileOperationRequest) (*FileOperationResult, error) {
+	for retry := 0; ; retry++ {
+		generation := c.currentGeneration()
+		resCh, err := c.send(req)
+		var res *FileOperationResult
+		if err == nil {
+			res, err = c.waitResult(req, resCh)
+		}
+		if err == nil || c.Reconnect == nil || retry >= c.MaxRetry || !idempotentOps[req.Op] || !isConnectionError(err) {
+			return res, err
+		}
+		if err := c.reconnect(generation); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *FSClient) send(req *FileOperationRequest) (<-chan *FileOperationResult, error) {
 	resCh := make(chan *FileOperationResult, 1)
 
 	c.locker.Lock()
 	c.reqCount++
 	c.wait[c.reqCount] = resCh
 	req.RID = c.reqCount
+	sendFunc := c.sendFunc
 	c.locker.Unlock()
 
-	err := c.sendFunc(req)
+	err := sendFunc(req)
 	if err != nil {
-		return nil, err
+		c.cancel(req)
+		return nil, &connectionError{err}
 	}
+	return resCh, nil
+}
+
+// sendNoReply sends a request without waiting response.
+func (c *FSClient) sendNoReply(req *FileOperationRequest) error {
+	c.locker.Lock()
+	sendFunc := c.sendFunc
+	c.locker.Unlock()
+	return sendFunc(req)
+}
+
+// cancel discards the response of the request.
+func (c *FSClient) cancel(req *FileOperationRequest) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	delete(c.wait, ridToUint32(req.RID))
+}
+
+func (c *FSClient) waitResult(req *FileOperationRequest, resCh <-chan *FileOperationResult) (*FileOperationResult, error) {
 	var res *FileOperationResult
+	timeout := c.Timeout
+	if longOps[req.Op] && c.LongTimeout > timeout {
+		timeout = c.LongTimeout
+	}
 	select {
-	case <-time.After(c.Timeout):
-		return nil, errors.New("timeout")
+	case <-time.After(timeout):
+		c.cancel(req)
+		return nil, errTimeout
 	case res = <-resCh:
 		if res == nil {
-			return nil, os.ErrClosed
+			return nil, errDisconnected
 		}
 	}
+	return res, resultError(req, res)
+}
+
+func resultError(req *FileOperationRequest, res *FileOperationResult) error {
 	if res.Error != "" {
 		// TODO: more errors
 		switch res.Error {
 		case "unexpected EOF":
-			return res, io.ErrUnexpectedEOF
+			return io.ErrUnexpectedEOF
 		case "EOF":
-			return res, io.EOF
+			return io.EOF
 		case "noent":
-			return res, &fs.PathError{Op: req.Op, Path: req.Path, Err: fs.ErrNotExist}
+			return &fs.PathError{Op: req.Op, Path: req.Path, Err: fs.ErrNotExist}
 		case "closed":
-			return res, &fs.PathError{Op: req.Op, Path: req.Path, Err: fs.ErrClosed}
+			return &fs.PathError{Op: req.Op, Path: req.Path, Err: fs.ErrClosed}
 		case "permission error":
-			return res, &fs.PathError{Op: req.Op, Path: req.Path, Err: fs.ErrPermission}
+			return &fs.PathError{Op: req.Op, Path: req.Path, Err: fs.ErrPermission}
 		case "invalid argument":
-			return res, &fs.PathError{Op: req.Op, Path: req.Path, Err: fs.ErrInvalid}
+			return &fs.PathError{Op: req.Op, Path: req.Path, Err: fs.ErrInvalid}
+		case "unsupported operation":
+			return &fs.PathError{Op: req.Op, Path: req.Path, Err: ErrUnsupported}
+		case "exist":
+			return &fs.PathError{Op: req.Op, Path: req.Path, Err: fs.ErrExist}
+		case "too many streams":
+			return &fs.PathError{Op: req.Op, Path: req.Path, Err: ErrTooManyStreams}
+		case "invalid handle":
+			return &fs.PathError{Op: req.Op, Path: req.Path, Err: ErrInvalidHandle}
 		default:
-			return res, &fs.PathError{Op: req.Op, Path: req.Path, Err: errors.New(res.Error)}
+			return &fs.PathError{Op: req.Op, Path: req.Path, Err: errors.New(res.Error)}
 		}
 	}
-	return res, nil
+	return nil
 }
 
 func (c *FSClient) HandleMessage(data []byte, isjson bool) error {
@@ -180,9 +339,15 @@ func (c *FSClient) HandleMessage(data []byte, isjson bool) error {
 		res.RID = float64(binary.LittleEndian.Uint32(data[4:]))
 		res.Buf = data[8:]
 	}
-	rid := uint32(res.RID.(float64))
+	rid := ridToUint32(res.RID)
 	c.locker.Lock()
-	if ch, ok := c.wait[rid]; ok {
+	if ch, ok := c.streams[rid]; ok {
+		select {
+		case ch <- &res:
+		default:
+			// broken server
+		}
+	} else if ch, ok := c.wait[rid]; ok {
 		ch <- &res
 		delete(c.wait, rid)
 	}
@@ -209,7 +374,7 @@ func (c *FSClient) Stat(name string) (fs.FileInfo, error) {
 		return stat, nil
 	}
 
-	res, err := c.request(&FileOperationRequest{Op: "stat", Path: name})
+	res, err := c.request(&FileOperationRequest{Op: "stat", Path: name, Options: c.statOptions()})
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			c.statCache.set(name, nil)
@@ -241,7 +406,8 @@ func (c *FSClient) ReadDirRange(name string, pos, limit int) ([]fs.DirEntry, err
 	if pos != 0 {
 		key += ";" + fmt.Sprint(pos)
 	}
-	if cached, l, ok := c.filesCache.get(key); ok && l >= limit {
+	options := c.statOptions()
+	if cached, l, ok := c.filesCache.get(key, options != nil); ok && l >= limit {
 		for _, f := range cached {
 			if len(entries) >= limit {
 				break
@@ -251,6 +417,15 @@ func (c *FSClient) ReadDirRange(name string, pos, limit int) ([]fs.DirEntry, err
 		return entries, nil
 	}
 
+	if c.capability().DirCursor {
+		var err error
+		if entries, err = c.readDirRangeCursor(name, pos, limit); err != nil {
+			return entries, err
+		}
+		c.filesCache.set(key, entries, limit, options != nil)
+		return entries, nil
+	}
+
 	for {
 		n := limit - len(entries)
 		if n <= 0 {
@@ -259,7 +434,7 @@ func (c *FSClient) ReadDirRange(name string, pos, limit int) ([]fs.DirEntry, err
 		if n > 200 {
 			n = 200
 		}
-		res, err := c.request(&FileOperationRequest{Op: "files", Path: name, Pos: int64(pos), Len: n})
+		res, err := c.request(&FileOperationRequest{Op: "files", Path: name, Pos: int64(pos), Len: n, Options: options})
 		if err != nil {
 			return entries, err
 		}
@@ -275,7 +450,7 @@ func (c *FSClient) ReadDirRange(name string, pos, limit int) ([]fs.DirEntry, err
 		}
 	}
 
-	c.filesCache.set(key, entries, limit)
+	c.filesCache.set(key, entries, limit, options != nil)
 
 	return entries, nil
 }
@@ -316,12 +491,44 @@ func (c *FSClient) Remove(name string) error {
 	return err
 }
 
+func (c *FSClient) ReadLink(name string) (string, error) {
+	res, err := c.request(&FileOperationRequest{Op: "readlink", Path: name})
+	if err != nil {
+		return "", err
+	}
+	var target string
+	err = json.Unmarshal(res.Data, &target)
+	return target, err
+}
+
+// Symlink creates name as a symbolic link to target.
+func (c *FSClient) Symlink(target, name string) error {
+	c.statCache.delete(name)
+	c.filesCache.delete(path.Dir(name))
+	_, err := c.request(&FileOperationRequest{Op: "symlink", Path: name, Options: map[string]string{"target": target}})
+	return err
+}
+
+// Link creates newName as a hard link to name.
+func (c *FSClient) Link(name, newName string) error {
+	c.statCache.delete(name)
+	c.statCache.delete(newName)
+	c.filesCache.delete(path.Dir(newName))
+	_, err := c.request(&FileOperationRequest{Op: "link", Path: name, Path2: newName})
+	return err
+}
+
 func (c *FSClient) Truncate(name string, size int64) error {
+	c.statCache.delete(name)
 	_, err := c.request(&FileOperationRequest{Op: "truncate", Path: name, Pos: size})
 	return err
 }
 
 func (c *FSClient) OpenWriter(name string, flag int) (io.WriteCloser, error) {
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		c.statCache.delete(name)
+		c.filesCache.delete(path.Dir(name))
+	}
 	var err error
 	if flag&os.O_TRUNC != 0 {
 		err = c.Truncate(name, 0)
@@ -342,37 +549,192 @@ func (f *clientDirEnt) Info() (fs.FileInfo, error) {
 }
 
 type clientFile struct {
-	c    *FSClient
-	name string
-	pos  int64
+	c      *FSClient
+	name   string
+	pos    int64
+	stream *clientReadStream
+
+	dirCursor int64
+	dirEOF    bool
+
+	readLock sync.Mutex
+	pending  []*pendingRequest
+	rbuf     []byte
+	rbufPos  int64
+	rbufEOF  bool
+	readSeq  int
+
+	writeLock sync.Mutex
+	writes    []*pendingRequest
+	writeErr  error
+}
+
+type pendingRequest struct {
+	req *FileOperationRequest
+	ch  <-chan *FileOperationResult
 }
 
 // fs.File
 func (f *clientFile) Stat() (fs.FileInfo, error) {
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
 	return f.c.Stat(f.name)
 }
 
 // fs.File, io.Reader
 func (f *clientFile) Read(b []byte) (int, error) {
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	if f.c.capability().ReadStream {
+		if f.stream != nil && f.stream.pos != f.pos {
+			f.stream.Close()
+			f.stream = nil
+		}
+		for retry := 0; ; retry++ {
+			generation := f.c.currentGeneration()
+			var n int
+			var err error
+			if f.stream == nil {
+				f.stream, err = f.c.openReadStream(f.name, f.pos, -1)
+			}
+			if err == nil {
+				n, err = f.stream.Read(b)
+				f.pos += int64(n)
+			}
+			if n == 0 && errors.Is(err, ErrTooManyStreams) {
+				f.stream.Close()
+				f.stream = nil
+				break
+			}
+			if n > 0 || f.c.Reconnect == nil || retry >= f.c.MaxRetry || !isConnectionError(err) {
+				if err != nil && f.stream != nil && n == 0 {
+					f.stream.Close()
+					f.stream = nil
+				}
+				return n, err
+			}
+			if f.stream != nil {
+				f.stream.Close()
+				f.stream = nil
+			}
+			if err := f.c.reconnect(generation); err != nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := f.readAt(b, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *clientFile) readAt(b []byte, off int64) (int, error) {
+	if f.c.ReadAhead > 0 {
+		return f.readAhead(b, off)
+	}
 	sz := len(b)
 	if sz > f.c.MaxReadSize {
 		sz = f.c.MaxReadSize
 	}
-	res, err := f.c.request(&FileOperationRequest{Op: "read", Path: f.name, Pos: f.pos, Len: sz})
+	res, err := f.c.request(&FileOperationRequest{Op: "read", Path: f.name, Pos: off, Len: sz})
+	if res == nil {
+		return 0, err
+	}
 	l := copy(b, res.Buf)
-	f.pos += int64(l)
 	if err == nil && l < sz {
 		err = io.EOF
 	}
 	return l, err
 }
 
+// readAhead reads b from the read buffer and keeps ReadAhead requests in flight.
+func (f *clientFile) readAhead(b []byte, off int64) (int, error) {
+	f.readLock.Lock()
+	defer f.readLock.Unlock()
+
+	if off < f.rbufPos || off >= f.rbufPos+int64(len(f.rbuf)) {
+		if f.rbufEOF && off >= f.rbufPos+int64(len(f.rbuf)) {
+			return 0, io.EOF
+		}
+		if f.rbuf != nil && off == f.rbufPos+int64(len(f.rbuf)) {
+			f.readSeq++
+		} else {
+			f.readSeq = 0
+		}
+		f.rbuf, f.rbufEOF = nil, false
+		// Drop requests before the position (seek)
+		for len(f.pending) > 0 && (off < f.pending[0].req.Pos || off >= f.pending[0].req.Pos+int64(f.pending[0].req.Len)) {
+			f.c.cancel(f.pending[0].req)
+			f.pending = f.pending[1:]
+		}
+		next := off
+		if len(f.pending) > 0 {
+			last := f.pending[len(f.pending)-1].req
+			next = last.Pos + int64(last.Len)
+		}
+		ahead := f.readSeq
+		if ahead > f.c.ReadAhead {
+			ahead = f.c.ReadAhead
+		}
+		for len(f.pending) <= ahead {
+			req := &FileOperationRequest{Op: "read", Path: f.name, Pos: next, Len: f.c.MaxReadSize}
+			ch, err := f.c.send(req)
+			if err != nil {
+				break
+			}
+			f.pending = append(f.pending, &pendingRequest{req: req, ch: ch})
+			next += int64(req.Len)
+		}
+		if len(f.pending) == 0 {
+			return 0, errDisconnected
+		}
+		p := f.pending[0]
+		f.pending = f.pending[1:]
+		res, err := f.c.waitResult(p.req, p.ch)
+		if isConnectionError(err) {
+			f.cancelReads()
+			res, err = f.c.request(&FileOperationRequest{Op: "read", Path: f.name, Pos: p.req.Pos, Len: p.req.Len})
+		}
+		if err != nil {
+			f.cancelReads()
+			return 0, err
+		}
+		f.rbuf, f.rbufPos = res.Buf, p.req.Pos
+		if len(res.Buf) < p.req.Len {
+			f.rbufEOF = true
+			f.cancelReads()
+		}
+		if off >= f.rbufPos+int64(len(f.rbuf)) {
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, f.rbuf[off-f.rbufPos:])
+	return n, nil
+}
+
+func (f *clientFile) cancelReads() {
+	for _, p := range f.pending {
+		f.c.cancel(p.req)
+	}
+	f.pending = nil
+}
+
+func (f *clientFile) resetReads() {
+	f.readLock.Lock()
+	defer f.readLock.Unlock()
+	f.cancelReads()
+	f.rbuf, f.rbufEOF = nil, false
+}
+
 // io.ReaderAt
 func (f *clientFile) ReadAt(b []byte, off int64) (int, error) {
-	f.pos = off
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
 	read := 0
 	for read < len(b) {
-		n, err := f.Read(b[read:])
+		n, err := f.readAt(b[read:], off+int64(read))
 		read += n
 		if err != nil {
 			return read, err
@@ -387,36 +749,93 @@ func (f *clientFile) Write(b []byte) (int, error) {
 }
 
 // io.WriterAt
+// Writes are pipelined. Errors may be reported by later WriteAt(), Sync() or Close().
 func (f *clientFile) WriteAt(b []byte, off int64) (int, error) {
+	f.resetReads()
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+	defer f.c.statCache.delete(f.name)
+
+	for _, w := range f.writes {
+		if off < w.req.Pos+int64(w.req.Len) && w.req.Pos < off+int64(len(b)) {
+			f.waitWrites(0) // overlapped
+			break
+		}
+	}
 	wrote := 0
-	for len(b) > 0 {
+	for len(b) > 0 && f.writeErr == nil {
 		l := len(b)
 		if l > f.c.MaxReadSize {
 			l = f.c.MaxReadSize
 		}
-		_, err := f.c.request(&FileOperationRequest{Op: "write", Path: f.name, Pos: off, Buf: b[:l]})
+		f.waitWrites(f.c.WriteWindow - 1)
+		if f.writeErr != nil {
+			break
+		}
+		req := &FileOperationRequest{Op: "write", Path: f.name, Pos: off, Buf: b[:l]}
+		req.SetBinary(f.c.capability().BinaryRequest)
+		ch, err := f.c.send(req)
 		if err != nil {
-			return wrote, err
+			f.writeErr = err
+			break
 		}
+		req.Buf = nil
+		req.Len = l
+		f.writes = append(f.writes, &pendingRequest{req: req, ch: ch})
 		wrote += l
 		off += int64(l)
 		b = b[l:]
 	}
 	f.pos = off
-	return wrote, nil
+	return wrote, f.writeErr
+}
+
+// waitWrites waits until outstanding writes <= n.
+func (f *clientFile) waitWrites(n int) {
+	for len(f.writes) > 0 && len(f.writes) > n {
+		w := f.writes[0]
+		f.writes = f.writes[1:]
+		_, err := f.c.waitResult(w.req, w.ch)
+		if err != nil && f.writeErr == nil {
+			f.writeErr = err
+		}
+	}
+}
+
+// Sync waits for all outstanding writes.
+func (f *clientFile) Sync() error {
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+	f.waitWrites(0)
+	return f.writeErr
 }
 
 func (f *clientFile) Truncate(size int64) error {
+	f.resetReads()
+	if err := f.Sync(); err != nil {
+		return err
+	}
 	return f.c.Truncate(f.name, size)
 }
 
 // fs.File
 func (f *clientFile) Close() error {
-	return nil
+	if f.stream != nil {
+		f.stream.Close()
+		f.stream = nil
+	}
+	f.resetReads()
+	if err := f.closeDir(); err != nil {
+		return err
+	}
+	return f.Sync()
 }
 
 // fs.ReadDirFile
 func (f *clientFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if f.c.capability().DirCursor {
+		return f.readDirCursor(n)
+	}
 	entries, err := f.c.ReadDirRange(f.name, int(f.pos), n)
 	f.pos += int64(len(entries))
 	if err == nil && len(entries) < n {
@@ -433,5 +852,9 @@ func (c *FSClient) Abort() error {
 		close(ch)
 	}
 	c.wait = map[uint32]chan *FileOperationResult{}
+	for _, ch := range c.streams {
+		close(ch)
+	}
+	c.streams = map[uint32]chan *FileOperationResult{}
 	return nil
 }
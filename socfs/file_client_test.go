@@ -1,11 +1,18 @@
 package socfs
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
 func newFakeClient(fsys fs.FS) *FSClient {
@@ -14,10 +21,11 @@ func newFakeClient(fsys fs.FS) *FSClient {
 	var client *FSClient
 	server := NewFSServer(fsys, 1)
 	client = NewFSClient(func(req *FileOperationRequest) error {
-		return server.HandleMessage(ctx, req.ToBytes(), true, func(res *FileOperationResult) error {
+		return server.HandleMessage(ctx, req.ToBytes(), req.IsJSON(), func(res *FileOperationResult) error {
 			return client.HandleMessage(res.ToBytes(), res.IsJSON())
 		})
 	})
+	client.SetCapability(server.FSCaps())
 	return client
 }
 
@@ -112,6 +120,104 @@ func TestFSClient_File(t *testing.T) {
 	}
 }
 
+func TestFSClient_ReadStream(t *testing.T) {
+	client := newFakeClient(os.DirFS(dir))
+	defer client.Abort()
+	client.MaxReadSize = 100
+	client.StreamWindow = 2
+
+	expected, err := os.ReadFile(dir + "/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(client, "/test.png")
+	if err != nil {
+		t.Fatal("ReadFile() error: ", err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Error("ReadFile() data error: ", len(data), len(expected))
+	}
+
+	r, err := client.OpenReadStream("/test.png", 10, 250)
+	if err != nil {
+		t.Fatal("OpenReadStream() error: ", err)
+	}
+	data, err = io.ReadAll(r)
+	if err != nil {
+		t.Fatal("ReadAll() error: ", err)
+	}
+	if !bytes.Equal(data, expected[10:260]) {
+		t.Error("stream data error: ", len(data))
+	}
+	r.Close()
+
+	r, err = client.OpenReadStream("/test.png", 0, -1)
+	if err != nil {
+		t.Fatal("OpenReadStream() error: ", err)
+	}
+	r.Read(make([]byte, 10))
+	if err := r.Close(); err != nil {
+		t.Error("Close() error: ", err)
+	}
+
+	r, _ = client.OpenReadStream("/not_exist_file", 0, -1)
+	_, err = io.ReadAll(r)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Error("Read() should be ErrNotExist: ", err)
+	}
+
+	useImageThumbnailer(t)
+	if data, err := fs.ReadFile(client, "/test.png"+ThumbnailSuffix); err != nil || len(data) == 0 {
+		t.Error("ReadFile() thumbnail error: ", err)
+	}
+
+	// fall back to read op
+	for i := 0; i < MaxStreams; i++ {
+		r, _ := client.OpenReadStream("/test.png", 0, -1)
+		defer r.Close()
+		r.Read(make([]byte, 1))
+	}
+	data, err = fs.ReadFile(client, "/test.png")
+	if err != nil || !bytes.Equal(data, expected) {
+		t.Error("ReadFile() should fall back to read op: ", err)
+	}
+}
+
+func TestFSClient_ReadAhead(t *testing.T) {
+	client := newFakeClient(os.DirFS(dir))
+	defer client.Abort()
+	client.SetCapability(&FSCapability{})
+	client.MaxReadSize = 100
+	client.ReadAhead = 3
+
+	expected, err := os.ReadFile(dir + "/test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := fs.ReadFile(client, "/test.png")
+	if err != nil {
+		t.Fatal("ReadFile() error: ", err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Error("ReadFile() data error: ", len(data), len(expected))
+	}
+
+	f, _ := client.Open("/test.png")
+	defer f.Close()
+	for _, off := range []int64{500, 50, 150, 120, int64(len(expected)) - 10} {
+		buf := make([]byte, 30)
+		n, err := f.(io.ReaderAt).ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			t.Fatal("ReadAt() error: ", err)
+		}
+		if !bytes.Equal(buf[:n], expected[off:off+int64(n)]) || n < 10 {
+			t.Error("ReadAt() data error: ", off, n)
+		}
+	}
+}
+
 func TestFSClient_Write(t *testing.T) {
 	fsys := WrapFS(NewWritableDirFS(dir))
 	client := newFakeClient(fsys)
@@ -181,6 +287,26 @@ func TestFSClient_Write(t *testing.T) {
 		t.Fatal("Create() shoudl be failed: ", err)
 	}
 
+	// OpenWriter invalidates the cached parent directory
+	if _, err := fs.ReadDir(client, "."); err != nil {
+		t.Fatal("ReadDir() error: ", err)
+	}
+	w, err = client.OpenWriter("new.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		t.Fatal("OpenWriter() error: ", err)
+	}
+	w.Write([]byte("new"))
+	w.Close()
+	entries, _ := fs.ReadDir(client, ".")
+	found := false
+	for _, ent := range entries {
+		found = found || ent.Name() == "new.txt"
+	}
+	if !found {
+		t.Error("new file should be listed")
+	}
+	client.Remove("new.txt")
+
 	// Readonly
 	fsys.ReadOnly()
 
@@ -199,3 +325,478 @@ func TestFSClient_Write(t *testing.T) {
 		t.Fatal("Truncate() should be failed with permission error: ", err)
 	}
 }
+
+func TestFSClient_WritePipeline(t *testing.T) {
+	tmpDir := t.TempDir()
+	fsys := WrapFS(NewWritableDirFS(tmpDir))
+	client := newFakeClient(fsys)
+	defer client.Abort()
+	client.MaxReadSize = 100
+	client.WriteWindow = 4
+
+	data := make([]byte, 1050)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	w, err := client.Create("test.bin")
+	if err != nil {
+		t.Fatal("Create() error: ", err)
+	}
+	n, err := w.Write(data)
+	if err != nil || n != len(data) {
+		t.Fatal("Write() error: ", n, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("Close() error: ", err)
+	}
+
+	written, err := os.ReadFile(tmpDir + "/test.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, data) {
+		t.Error("written data error: ", len(written))
+	}
+
+	fsys.ReadOnly()
+	w, _ = client.OpenWriter("test.bin", os.O_WRONLY)
+	w.Write(data)
+	if err := w.Close(); !errors.Is(err, fs.ErrPermission) {
+		t.Error("Close() should be failed with permission error: ", err)
+	}
+}
+
+func TestFSClient_Reconnect(t *testing.T) {
+	ctx := context.Background()
+	server := NewFSServer(os.DirFS(dir), 1)
+	var client *FSClient
+	send := func(req *FileOperationRequest) error {
+		return server.HandleMessage(ctx, req.ToBytes(), req.IsJSON(), func(res *FileOperationResult) error {
+			return client.HandleMessage(res.ToBytes(), res.IsJSON())
+		})
+	}
+	client = NewFSClient(func(req *FileOperationRequest) error {
+		return errors.New("disconnected")
+	})
+	client.SetCapability(server.FSCaps())
+	reconnected := 0
+	client.Reconnect = func() error {
+		reconnected++
+		client.SetSendFunc(send)
+		return nil
+	}
+
+	_, err := client.Stat("/test.png")
+	if err != nil {
+		t.Fatal("Stat() error: ", err)
+	}
+	if reconnected != 1 {
+		t.Error("reconnect count error: ", reconnected)
+	}
+
+	generation := client.SetSendFunc(func(req *FileOperationRequest) error {
+		return errors.New("disconnected")
+	})
+	client.AbortConnection(generation)
+	_, err = fs.ReadFile(client, "/test.png")
+	if err != nil {
+		t.Fatal("ReadFile() error: ", err)
+	}
+	if reconnected != 2 {
+		t.Error("reconnect count error: ", reconnected)
+	}
+
+	// not idempotent
+	client.SetSendFunc(func(req *FileOperationRequest) error {
+		return errors.New("disconnected")
+	})
+	err = client.Mkdir("test", fs.ModePerm)
+	if err == nil {
+		t.Error("Mkdir() should be failed")
+	}
+
+	// timeout is not a connection error
+	client.SetSendFunc(func(req *FileOperationRequest) error { return nil })
+	client.Timeout = 10 * time.Millisecond
+	reconnected = 0
+	_, err = client.Stat("/timeout.png")
+	if !errors.Is(err, os.ErrDeadlineExceeded) || reconnected != 0 {
+		t.Error("Stat() should be timeout without reconnecting: ", err, reconnected)
+	}
+}
+
+func TestFSClient_Hash(t *testing.T) {
+	client := newFakeClient(os.DirFS(dir))
+	defer client.Abort()
+
+	data, _ := os.ReadFile(dir + "/test.png")
+	expected := sha256.Sum256(data)
+	res, err := client.Hash("test.png", "", 0, -1)
+	if err != nil {
+		t.Fatal("Hash() error: ", err)
+	}
+	if res.Hash != hex.EncodeToString(expected[:]) || res.Size != int64(len(data)) {
+		t.Error("Hash() mismatch: ", res)
+	}
+
+	expected = sha256.Sum256(data[100:1100])
+	res, err = client.Hash("test.png", "sha256", 100, 1000)
+	if err != nil {
+		t.Fatal("Hash() error: ", err)
+	}
+	if res.Hash != hex.EncodeToString(expected[:]) || res.Size != 1000 {
+		t.Error("Hash() range mismatch: ", res)
+	}
+
+	_, err = client.Hash("test.png", "unknown", 0, -1)
+	if !errors.Is(err, ErrUnsupported) {
+		t.Error("Hash() should be unsupported: ", err)
+	}
+}
+
+func TestFSClient_OpenDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	for i := 0; i < 5; i++ {
+		os.WriteFile(tmpDir+"/"+string(rune('a'+i))+".txt", []byte("test"), 0644)
+	}
+	client := newFakeClient(os.DirFS(tmpDir))
+	defer client.Abort()
+
+	d, err := client.OpenDir("/")
+	if err != nil {
+		t.Fatal("OpenDir() error: ", err)
+	}
+	var names []string
+	for {
+		entries, err := d.ReadDir(2)
+		for _, ent := range entries {
+			names = append(names, ent.Name())
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("ReadDir() error: ", err)
+		}
+		if len(entries) == 0 {
+			t.Fatal("ReadDir() returns no entries")
+		}
+	}
+	if len(names) != 5 {
+		t.Error("ReadDir() entries: ", names)
+	}
+	if err := d.Close(); err != nil {
+		t.Error("Close() error: ", err)
+	}
+
+	dirCursorExpireTime = -time.Second
+	defer func() { dirCursorExpireTime = time.Minute }()
+	d, _ = client.OpenDir("/")
+	if _, err := d.ReadDir(1); !errors.Is(err, ErrInvalidHandle) {
+		t.Error("cursor should be expired: ", err)
+	}
+}
+
+func TestFSClient_ReadDirCursor(t *testing.T) {
+	tmpDir := t.TempDir()
+	for i := 0; i < 450; i++ {
+		os.WriteFile(fmt.Sprintf("%s/%03d.txt", tmpDir, i), nil, 0644)
+	}
+	var lock sync.Mutex
+	ops := map[string]int{}
+	server := NewFSServer(os.DirFS(tmpDir), 1)
+	var client *FSClient
+	client = NewFSClient(func(req *FileOperationRequest) error {
+		lock.Lock()
+		ops[req.Op]++
+		lock.Unlock()
+		return server.HandleMessage(context.Background(), req.ToBytes(), req.IsJSON(), func(res *FileOperationResult) error {
+			return client.HandleMessage(res.ToBytes(), res.IsJSON())
+		})
+	})
+	client.SetCapability(server.FSCaps())
+	defer client.Abort()
+
+	entries, err := client.ReadDir("/")
+	if err != nil || len(entries) != 450 {
+		t.Fatal("ReadDir() error: ", len(entries), err)
+	}
+	page, err := client.ReadDirRange("/", 200, 100)
+	if err != nil || len(page) != 100 || page[0].Name() != entries[200].Name() {
+		t.Error("ReadDirRange() error: ", len(page), err)
+	}
+	if page, err := client.ReadDirRange("/", 500, 100); err != nil || len(page) != 0 {
+		t.Error("ReadDirRange() out of range: ", len(page), err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if ops["files"] != 0 || ops["opendir"] != 3 || ops["closedir"] != 3 {
+		t.Error("ReadDir() should use directory cursors: ", ops)
+	}
+}
+
+func TestFSClient_Search(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(tmpDir+"/a/b", 0755)
+	os.WriteFile(tmpDir+"/a/test1.jpg", []byte("test"), 0644)
+	os.WriteFile(tmpDir+"/a/b/test2.jpg", []byte("test123"), 0644)
+	os.WriteFile(tmpDir+"/a/b/test3.txt", []byte("test"), 0644)
+	client := newFakeClient(os.DirFS(tmpDir))
+	defer client.Abort()
+
+	search := func(root string, opt *SearchOptions, n int) ([]*FileEntry, error) {
+		d, err := client.Search(root, opt)
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		var files []*FileEntry
+		for {
+			entries, err := d.ReadDir(n)
+			for _, ent := range entries {
+				info, _ := ent.Info()
+				files = append(files, info.(*FileEntry))
+			}
+			if err == io.EOF || n <= 0 {
+				return files, nil
+			} else if err != nil {
+				return files, err
+			}
+		}
+	}
+
+	files, err := search("/", nil, -1)
+	if err != nil || len(files) != 5 {
+		t.Fatal("Search() error: ", err, files)
+	}
+
+	files, err = search("/a", &SearchOptions{Type: "image/"}, -1)
+	if err != nil || len(files) != 2 {
+		t.Fatal("Search() type error: ", err, files)
+	}
+	if files[0].Path != "/a/b/test2.jpg" || files[1].Path != "/a/test1.jpg" {
+		t.Error("Search() path: ", files[0].Path, files[1].Path)
+	}
+
+	files, _ = search("/", &SearchOptions{Pattern: "*.jpg", MinSize: 5}, -1)
+	if len(files) != 1 || files[0].Name() != "test2.jpg" {
+		t.Error("Search() size error: ", files)
+	}
+
+	files, _ = search("/", &SearchOptions{Name: "TEST"}, 1)
+	if len(files) != 3 || files[1].Name() != "test3.txt" {
+		t.Error("Search() paging error: ", files)
+	}
+
+	_, err = client.Search("/", &SearchOptions{Pattern: "["})
+	if !errors.Is(err, fs.ErrInvalid) {
+		t.Error("Search() should be failed: ", err)
+	}
+}
+
+func TestFSClient_ExtendedStat(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(tmpDir+"/test.txt", []byte("test"), 0640)
+	os.Chmod(tmpDir+"/test.txt", 0640)
+	client := newFakeClient(NewWritableDirFS(tmpDir))
+	defer client.Abort()
+	client.ExtendedStat = true
+
+	stat, err := client.Stat("test.txt")
+	if err != nil {
+		t.Fatal("Stat() error: ", err)
+	}
+	if stat.Mode() != 0640 {
+		t.Error("Mode() mismatch: ", stat.Mode())
+	}
+
+	if err := os.Symlink("test.txt", tmpDir+"/link"); err != nil {
+		t.Skip("symlink is not supported: ", err)
+	}
+	files, err := client.ReadDir("/")
+	if err != nil {
+		t.Fatal("ReadDir() error: ", err)
+	}
+	for _, f := range files {
+		ent := f.(*clientDirEnt).FileEntry
+		if f.Name() == "link" && (ent.Mode()&fs.ModeSymlink == 0 || ent.LinkTarget != "test.txt") {
+			t.Error("symlink mismatch: ", ent.Mode(), ent.LinkTarget)
+		}
+	}
+
+	for _, m := range []fs.FileMode{0755, fs.ModeDir | 0700, fs.ModeSymlink | 0777, fs.ModeDevice | fs.ModeCharDevice | 0600, fs.ModeSetuid | fs.ModeSticky | 0644} {
+		if fileModeFromUnix(unixMode(m)) != m {
+			t.Error("unixMode() round trip failed: ", m)
+		}
+	}
+}
+
+func TestFSClient_Symlink(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(tmpDir+"/test.txt", []byte("test"), 0644)
+	client := newFakeClient(NewWritableDirFS(tmpDir).RestrictSymlinks())
+	defer client.Abort()
+
+	if err := client.Symlink("test.txt", "link"); err != nil {
+		t.Skip("symlink is not supported: ", err)
+	}
+	if target, err := client.ReadLink("link"); err != nil || target != "test.txt" {
+		t.Error("ReadLink() error: ", target, err)
+	}
+	files, _ := client.ReadDir("/")
+	for _, f := range files {
+		if f.Name() == "link" && f.(*clientDirEnt).FileEntry.Type != ContentTypeByPath("link") {
+			t.Error("Type should be content type: ", f.(*clientDirEnt).FileEntry.Type)
+		}
+	}
+	client.ExtendedStat = true
+	files, _ = client.ReadDir("/")
+	for _, f := range files {
+		if f.Name() == "link" && f.Type() != fs.ModeSymlink {
+			t.Error("Type() should be symlink: ", f.Type())
+		}
+	}
+	if err := client.Symlink("../outside", "link2"); !errors.Is(err, fs.ErrPermission) {
+		t.Error("Symlink() should be failed: ", err)
+	}
+
+	// targets are checked without RestrictSymlinks()
+	client2 := newFakeClient(NewWritableDirFS(tmpDir))
+	defer client2.Abort()
+	for _, target := range []string{"../outside", "/etc/passwd", "a/../../outside"} {
+		if err := client2.Symlink(target, "link3"); !errors.Is(err, fs.ErrPermission) {
+			t.Error("Symlink() should be failed: ", target, err)
+		}
+	}
+	if _, err := os.Lstat(tmpDir + "/link3"); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("symlink should not be created: ", err)
+	}
+	// d/.. is the parent of the root if d is a link to "."
+	if err := client2.Symlink(".", "d"); err != nil {
+		t.Fatal("Symlink() error: ", err)
+	}
+	if err := client2.Symlink("d/..", "up"); !errors.Is(err, fs.ErrPermission) {
+		t.Error("Symlink() should be failed: ", err)
+	}
+	if err := client2.Symlink("test.txt", "d/link4"); !errors.Is(err, fs.ErrPermission) {
+		t.Error("Symlink() through symlink should be failed: ", err)
+	}
+
+	if err := client.Link("test.txt", "hardlink"); err != nil {
+		t.Fatal("Link() error: ", err)
+	}
+	if data, _ := os.ReadFile(tmpDir + "/hardlink"); string(data) != "test" {
+		t.Error("Link() data mismatch: ", string(data))
+	}
+	if _, err := client.ReadLink("test.txt"); err == nil {
+		t.Error("ReadLink() should be failed")
+	}
+}
+
+func TestFSClient_CopyMove(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(tmpDir+"/a/b", 0755)
+	os.WriteFile(tmpDir+"/a/x.txt", []byte("xxx"), 0644)
+	os.WriteFile(tmpDir+"/a/b/y.txt", []byte("yyy"), 0644)
+	client := newFakeClient(NewWritableDirFS(tmpDir))
+	defer client.Abort()
+
+	if err := client.Copy("a", "c", false); err != nil {
+		t.Fatal("Copy() error: ", err)
+	}
+	if data, _ := os.ReadFile(tmpDir + "/c/b/y.txt"); string(data) != "yyy" {
+		t.Error("Copy() data mismatch: ", string(data))
+	}
+	if err := client.Copy("a/x.txt", "c/b/y.txt", false); !errors.Is(err, fs.ErrExist) {
+		t.Error("Copy() should be failed: ", err)
+	}
+	if err := client.Copy("a/x.txt", "c/b/y.txt", true); err != nil {
+		t.Error("Copy() error: ", err)
+	}
+	if data, _ := os.ReadFile(tmpDir + "/c/b/y.txt"); string(data) != "xxx" {
+		t.Error("Copy() overwrite mismatch: ", string(data))
+	}
+	if err := client.Copy("a", "a/b/a", false); !errors.Is(err, fs.ErrInvalid) {
+		t.Error("Copy() into itself should be failed: ", err)
+	}
+
+	if err := client.Move("c/b", "a", false); !errors.Is(err, fs.ErrExist) {
+		t.Error("Move() should be failed: ", err)
+	}
+	if err := client.Move("c/b", "a/b", true); err != nil {
+		t.Fatal("Move() error: ", err)
+	}
+	if data, _ := os.ReadFile(tmpDir + "/a/b/y.txt"); string(data) != "xxx" {
+		t.Error("Move() data mismatch: ", string(data))
+	}
+	if _, err := os.Stat(tmpDir + "/c/b"); !os.IsNotExist(err) {
+		t.Error("c/b should be moved: ", err)
+	}
+
+	// missing sources keep the destination
+	if err := client.Copy("nothing", "a/x.txt", true); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("Copy() should be failed: ", err)
+	}
+	if err := client.Move("nothing", "a/b", true); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("Move() should be failed: ", err)
+	}
+	if _, err := os.Stat(tmpDir + "/a/b/y.txt"); err != nil {
+		t.Error("destination should be kept: ", err)
+	}
+
+	os.WriteFile(tmpDir+"/c/old.txt", []byte("old"), 0644)
+	if err := client.Copy("a", "c", true); err != nil {
+		t.Fatal("Copy() error: ", err)
+	}
+	if _, err := os.Stat(tmpDir + "/c/old.txt"); !os.IsNotExist(err) {
+		t.Error("c should be replaced: ", err)
+	}
+	if data, _ := os.ReadFile(tmpDir + "/c/x.txt"); string(data) != "xxx" {
+		t.Error("Copy() overwrite mismatch: ", string(data))
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 2 {
+		t.Error("temporary files should be removed: ", entries)
+	}
+
+	if err := os.Symlink(".", tmpDir+"/a/loop"); err == nil {
+		if err := client.Copy("a", "d", false); err != nil {
+			t.Error("Copy() symlink error: ", err)
+		}
+		if target, _ := os.Readlink(tmpDir + "/d/loop"); target != "." {
+			t.Error("symlink should be copied as link: ", target)
+		}
+	}
+
+	if err := client.Remove("a"); err == nil {
+		t.Error("Remove() non-empty dir should be failed")
+	}
+	if err := client.RemoveAll("a"); err != nil {
+		t.Error("RemoveAll() error: ", err)
+	}
+	if _, err := os.Stat(tmpDir + "/a"); !os.IsNotExist(err) {
+		t.Error("a should be removed: ", err)
+	}
+	if err := client.RemoveAll("/"); !errors.Is(err, fs.ErrPermission) {
+		t.Error("RemoveAll() root should be failed: ", err)
+	}
+}
+
+func TestFSClient_LongTimeout(t *testing.T) {
+	var client *FSClient
+	client = NewFSClient(func(req *FileOperationRequest) error {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			client.HandleMessage((&FileOperationResult{RID: req.RID}).ToBytes(), true)
+		}()
+		return nil
+	})
+	client.Timeout = 10 * time.Millisecond
+	client.LongTimeout = time.Second
+
+	if _, err := client.Stat("test.txt"); !errors.Is(err, errTimeout) {
+		t.Error("Stat() should be timed out: ", err)
+	}
+	if err := client.Copy("a", "b", false); err != nil {
+		t.Error("Copy() error: ", err)
+	}
+}
@@ -0,0 +1,228 @@
+package socfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// RemoveAll removes name and any children it contains.
+func (w *WrappedFS) RemoveAll(name string) error {
+	if name == "." {
+		return &fs.PathError{Op: "removeAll", Path: name, Err: fs.ErrPermission}
+	}
+	if w.removeAllFS != nil {
+		return w.removeAllFS.RemoveAll(name)
+	}
+	err := w.Remove(name)
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	entries, rerr := w.ReadDir(name)
+	if rerr != nil {
+		return err
+	}
+	for _, ent := range entries {
+		if err := w.RemoveAll(path.Join(name, ent.Name())); err != nil {
+			return err
+		}
+	}
+	return w.Remove(name)
+}
+
+// checkCopyPath returns an error if newName is name itself or under name.
+func checkCopyPath(op, name, newName string) error {
+	if name == "." || newName == "." || strings.HasPrefix(newName+"/", name+"/") {
+		return &fs.PathError{Op: op, Path: newName, Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+// Copy copies a file or a directory tree. Existing newName is replaced if overwrite is true.
+func (w *WrappedFS) Copy(name, newName string, overwrite bool) error {
+	if err := checkCopyPath("copy", name, newName); err != nil {
+		return err
+	}
+	if w.openWriterFS == nil {
+		return fs.ErrPermission
+	}
+	if _, err := w.Stat(name); err != nil {
+		return err
+	}
+	_, err := w.Stat(newName)
+	if err == nil && !overwrite {
+		return &fs.PathError{Op: "copy", Path: newName, Err: fs.ErrExist}
+	}
+	if err != nil {
+		return w.copyAll(name, newName)
+	}
+	if w.renameFS == nil {
+		if err := w.RemoveAll(newName); err != nil {
+			return err
+		}
+		return w.copyAll(name, newName)
+	}
+	// copy next to the destination and replace it on success.
+	tmp := tempName(newName)
+	if err := w.copyAll(name, tmp); err != nil {
+		return err
+	}
+	if err := w.replace(tmp, newName); err != nil {
+		w.RemoveAll(tmp)
+		return err
+	}
+	return nil
+}
+
+// tempName returns a hidden name in the same directory as name.
+func tempName(name string) string {
+	return path.Join(path.Dir(name), fmt.Sprintf(".%s.%d.tmp", path.Base(name), time.Now().UnixNano()))
+}
+
+// replace renames tmp to name. Existing name is removed if it can't be replaced by rename.
+func (w *WrappedFS) replace(tmp, name string) error {
+	if err := w.Rename(tmp, name); err == nil {
+		return nil
+	}
+	if err := w.RemoveAll(name); err != nil {
+		return err
+	}
+	return w.Rename(tmp, name)
+}
+
+// copyAll copies name to newName and removes partially copied newName on error.
+func (w *WrappedFS) copyAll(name, newName string) error {
+	err := w.copy(name, newName)
+	if err != nil {
+		w.RemoveAll(newName)
+	}
+	return err
+}
+
+func (w *WrappedFS) copy(name, newName string) error {
+	// symlinks are copied as links and not followed.
+	if target, err := w.ReadLink(name); err == nil {
+		return w.Symlink(target, newName)
+	}
+	stat, err := w.Stat(name)
+	if err != nil {
+		return err
+	}
+	if stat.IsDir() {
+		if err := w.Mkdir(newName, fs.ModePerm); err != nil {
+			return err
+		}
+		entries, err := w.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, ent := range entries {
+			if ent.Type()&fs.ModeSymlink != 0 && w.readLinkFS == nil {
+				continue // can't copy the link itself.
+			}
+			if err := w.copy(path.Join(name, ent.Name()), path.Join(newName, ent.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	src, err := w.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := w.OpenWriter(newName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// Move renames name to newName. Falls back to copy and remove if rename fails.
+func (w *WrappedFS) Move(name, newName string, overwrite bool) error {
+	if err := checkCopyPath("move", name, newName); err != nil {
+		return err
+	}
+	if _, err := w.Stat(name); err != nil {
+		return err
+	}
+	target := newName
+	if _, err := w.Stat(newName); err == nil {
+		if !overwrite {
+			return &fs.PathError{Op: "move", Path: newName, Err: fs.ErrExist}
+		}
+		target = tempName(newName)
+	}
+	err := w.Rename(name, target)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+	if err != nil {
+		if err := w.Copy(name, newName, true); err != nil {
+			return err
+		}
+		return w.RemoveAll(name)
+	}
+	if target != newName {
+		if err := w.replace(target, newName); err != nil {
+			w.Rename(target, name)
+			return err
+		}
+	}
+	return nil
+}
+
+func (fsys *writableDirFS) RemoveAll(name string) error {
+	dir, base, err := fsys.openParent("removeAll", name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return pathError("removeAll", name, removeAllAt(dir, base))
+}
+
+func overwriteOptions(overwrite bool) map[string]string {
+	if overwrite {
+		return map[string]string{"overwrite": "true"}
+	}
+	return nil
+}
+
+// RemoveAll removes name and any children it contains.
+func (c *FSClient) RemoveAll(name string) error {
+	c.statCache.delete(name)
+	c.filesCache.delete(name)
+	c.filesCache.delete(path.Dir(name))
+	_, err := c.request(&FileOperationRequest{Op: "removeAll", Path: name})
+	return err
+}
+
+// Copy copies a file or a directory tree on the server.
+func (c *FSClient) Copy(name, newName string, overwrite bool) error {
+	c.statCache.delete(newName)
+	c.filesCache.delete(newName)
+	c.filesCache.delete(path.Dir(newName))
+	_, err := c.request(&FileOperationRequest{Op: "copy", Path: name, Path2: newName, Options: overwriteOptions(overwrite)})
+	return err
+}
+
+// Move moves a file or a directory on the server.
+func (c *FSClient) Move(name, newName string, overwrite bool) error {
+	c.statCache.delete(name)
+	c.statCache.delete(newName)
+	c.filesCache.delete(name)
+	c.filesCache.delete(newName)
+	c.filesCache.delete(path.Dir(name))
+	c.filesCache.delete(path.Dir(newName))
+	_, err := c.request(&FileOperationRequest{Op: "move", Path: name, Path2: newName, Options: overwriteOptions(overwrite)})
+	return err
+}
@@ -0,0 +1,269 @@
+package socfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+)
+
+// Directory cursor ops:
+//
+//	request: {op: "opendir", path}
+//	response: {cursor: id}
+//	request: {op: "readdir", h: cursor, l: max entries}
+//	response: [FileEntry...]  fewer than l entries means EOF.
+//	request: {op: "closedir", h: cursor}
+//
+// Cursors are closed if they are not used for dirCursorExpireTime.
+var dirCursorExpireTime = time.Minute
+
+const maxDirCursors = 64
+
+type dirCursor struct {
+	lock sync.Mutex
+	dir  fs.ReadDirFile
+	walk *searchWalk // search cursor
+	path string
+	used time.Time
+	refs int // requests using the cursor
+}
+
+func (d *dirCursor) close() error {
+	if d.dir == nil {
+		return nil
+	}
+	return d.dir.Close()
+}
+
+type dirCursors struct {
+	lock    sync.Mutex
+	cursors map[int64]*dirCursor
+	lastID  int64
+}
+
+func newDirCursors() *dirCursors {
+	return &dirCursors{cursors: map[int64]*dirCursor{}}
+}
+
+func (c *dirCursors) open(fsys fs.FS, name string) (int64, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		f.Close()
+		return 0, &fs.PathError{Op: "opendir", Path: name, Err: errors.New("not a directory")}
+	}
+	return c.add(&dirCursor{dir: dir, path: name}), nil
+}
+
+func (c *dirCursors) add(d *dirCursor) int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lastID++
+	d.used = time.Now()
+	c.cursors[c.lastID] = d
+	c.expire()
+	return c.lastID
+}
+
+// expire closes expired or least recently used cursors. Cursors in use are not closed. (locked)
+func (c *dirCursors) expire() {
+	expire := time.Now().Add(-dirCursorExpireTime)
+	for id, d := range c.cursors {
+		if d.refs == 0 && d.used.Before(expire) {
+			delete(c.cursors, id)
+			d.close()
+		}
+	}
+	for len(c.cursors) > maxDirCursors {
+		var oldest int64
+		for id, d := range c.cursors {
+			if d.refs == 0 && (oldest == 0 || d.used.Before(c.cursors[oldest].used)) {
+				oldest = id
+			}
+		}
+		if oldest == 0 {
+			return
+		}
+		c.cursors[oldest].close()
+		delete(c.cursors, oldest)
+	}
+}
+
+// get returns the cursor. release() must be called after use.
+func (c *dirCursors) get(id int64) (*dirCursor, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.expire()
+	d, ok := c.cursors[id]
+	if !ok {
+		return nil, ErrInvalidHandle
+	}
+	d.used = time.Now()
+	d.refs++
+	return d, nil
+}
+
+func (c *dirCursors) release(d *dirCursor) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	d.refs--
+	d.used = time.Now()
+}
+
+func (c *dirCursors) close(id int64) error {
+	c.lock.Lock()
+	d, ok := c.cursors[id]
+	delete(c.cursors, id)
+	c.lock.Unlock()
+	if !ok {
+		return ErrInvalidHandle
+	}
+	return d.close()
+}
+
+func (c *dirCursors) closeAll() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for id, d := range c.cursors {
+		d.close()
+		delete(c.cursors, id)
+	}
+}
+
+func (h *FSServer) readDirCursor(op *FileOperationRequest, acl *ACL) ([]*FileEntry, error) {
+	d, err := h.dirs.get(op.Handle)
+	if err != nil {
+		return nil, err
+	}
+	defer h.dirs.release(d)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	n := op.Len
+	if n <= 0 || n > 1000 {
+		n = 1000
+	}
+	if d.walk != nil {
+		return h.readSearch(op, acl, d.walk, n), nil
+	}
+	files := []*FileEntry{}
+	for len(files) < n {
+		entries, err := d.dir.ReadDir(n - len(files))
+		for _, ent := range entries {
+			p := path.Join(d.path, ent.Name())
+			if !acl.visible(p) {
+				continue
+			}
+			if info, err := ent.Info(); err == nil {
+				files = append(files, h.newFileEntry(op, p, info, h.FSCaps().Write && acl.allowed(p)))
+			}
+		}
+		if err == io.EOF || len(entries) == 0 {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// readDirCursor reads entries using the directory cursor.
+func (f *clientFile) readDirCursor(n int) ([]fs.DirEntry, error) {
+	if f.dirEOF {
+		if n <= 0 {
+			return nil, nil
+		}
+		return nil, io.EOF
+	}
+	if f.dirCursor == 0 {
+		cursor, err := f.c.openCursor(&FileOperationRequest{Op: "opendir", Path: f.name})
+		if err != nil {
+			return nil, err
+		}
+		f.dirCursor = cursor
+	}
+	var entries []fs.DirEntry
+	for n <= 0 || len(entries) < n {
+		l := 200
+		if n > 0 && n-len(entries) < l {
+			l = n - len(entries)
+		}
+		res, err := f.c.request(&FileOperationRequest{Op: "readdir", Handle: f.dirCursor, Len: l, Options: f.c.statOptions()})
+		if err != nil {
+			return entries, err
+		}
+		var result []*FileEntry
+		json.Unmarshal(res.Data, &result)
+		for _, ent := range result {
+			entries = append(entries, &clientDirEnt{FileEntry: ent})
+			if ent.Path != "" {
+				f.c.statCache.set(ent.Path, ent)
+			} else {
+				f.c.statCache.set(path.Join(f.name, ent.Name()), ent)
+			}
+		}
+		f.pos += int64(len(result))
+		if len(result) < l {
+			f.dirEOF = true
+			break
+		}
+	}
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	return entries, nil
+}
+
+// readDirRangeCursor reads entries from pos using a directory cursor. The "files" op reads the whole directory for each page.
+func (c *FSClient) readDirRangeCursor(name string, pos, limit int) ([]fs.DirEntry, error) {
+	if limit == 0 {
+		return nil, nil
+	}
+	f := &clientFile{c: c, name: name}
+	defer f.closeDir()
+	for pos > 0 {
+		skipped, err := f.readDirCursor(pos)
+		if err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		pos -= len(skipped)
+	}
+	entries, err := f.readDirCursor(limit)
+	if err == io.EOF {
+		return entries, nil
+	}
+	return entries, err
+}
+
+// openCursor sends the "opendir" or "search" request and returns the cursor.
+func (c *FSClient) openCursor(req *FileOperationRequest) (int64, error) {
+	res, err := c.request(req)
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		Cursor int64 `json:"cursor"`
+	}
+	if err := json.Unmarshal(res.Data, &result); err != nil {
+		return 0, err
+	}
+	return result.Cursor, nil
+}
+
+func (f *clientFile) closeDir() error {
+	if f.dirCursor == 0 {
+		return nil
+	}
+	_, err := f.c.request(&FileOperationRequest{Op: "closedir", Handle: f.dirCursor})
+	f.dirCursor = 0
+	return err
+}
@@ -0,0 +1,215 @@
+package socfs
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidHandle = errors.New("invalid handle")
+var ErrTooManyHandles = errors.New("too many open handles")
+
+var fileHandleExpireTime = time.Second * 10
+
+// Max explicitly opened handles per session
+const maxOpenHandles = 64
+
+type fileHandleKey struct {
+	path  string
+	write bool
+}
+
+type fileHandle struct {
+	key     fileHandleKey
+	file    io.Closer
+	refs    int
+	evicted bool
+	used    time.Time
+	elem    *list.Element
+}
+
+// fileHandleCache keeps recently used files open. Explicitly opened handles are not evicted until closed.
+// Cached files are not checked for each request. Files replaced outside of the server are reopened after they expire.
+type fileHandleCache struct {
+	lock    sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[fileHandleKey]*fileHandle
+	handles map[int64]*fileHandle
+	lastID  int64
+	timer   *time.Timer // closes idle files
+}
+
+func newFileHandleCache(size int) *fileHandleCache {
+	return &fileHandleCache{size: size, lru: list.New(), entries: map[fileHandleKey]*fileHandle{}, handles: map[int64]*fileHandle{}}
+}
+
+func (c *fileHandleCache) acquire(key fileHandleKey, open func() (io.Closer, error)) (*fileHandle, error) {
+	c.lock.Lock()
+	if h, ok := c.entries[key]; ok && !h.used.Before(time.Now().Add(-fileHandleExpireTime)) {
+		h.refs++
+		h.used = time.Now()
+		c.lru.MoveToFront(h.elem)
+		c.lock.Unlock()
+		return h, nil
+	} else if ok {
+		c.remove(h)
+	}
+	c.lock.Unlock()
+
+	f, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if h, ok := c.entries[key]; ok {
+		// opened by another request
+		f.Close()
+		h.refs++
+		h.used = time.Now()
+		c.lru.MoveToFront(h.elem)
+		return h, nil
+	}
+	h := &fileHandle{key: key, file: f, refs: 1, used: time.Now()}
+	h.elem = c.lru.PushFront(h)
+	c.entries[key] = h
+	c.evict()
+	c.schedule()
+	return h, nil
+}
+
+// discard removes the cached file after an error. The file is reopened by the next request.
+func (c *fileHandleCache) discard(h *fileHandle) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.entries[h.key] == h {
+		c.remove(h)
+	}
+}
+
+// schedule starts the timer to close idle files. (locked)
+func (c *fileHandleCache) schedule() {
+	if c.timer == nil && c.lru.Len() > 0 {
+		c.timer = time.AfterFunc(fileHandleExpireTime, c.sweep)
+	}
+}
+
+func (c *fileHandleCache) sweep() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.timer = nil
+	c.evict()
+	c.schedule()
+}
+
+func (c *fileHandleCache) release(h *fileHandle) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.unref(h)
+}
+
+func (c *fileHandleCache) unref(h *fileHandle) {
+	h.refs--
+	if h.evicted && h.refs == 0 {
+		h.file.Close()
+	}
+}
+
+func (c *fileHandleCache) remove(h *fileHandle) {
+	if h.evicted {
+		return
+	}
+	h.evicted = true
+	c.lru.Remove(h.elem)
+	delete(c.entries, h.key)
+	if h.refs == 0 {
+		h.file.Close()
+	}
+}
+
+// evict closes expired or least recently used files. (locked)
+func (c *fileHandleCache) evict() {
+	expire := time.Now().Add(-fileHandleExpireTime)
+	for e := c.lru.Back(); e != nil; {
+		h := e.Value.(*fileHandle)
+		e = e.Prev()
+		if c.lru.Len() > c.size || h.used.Before(expire) && h.refs == 0 {
+			c.remove(h)
+		}
+	}
+}
+
+// invalidate closes cached files under the path. Explicitly opened handles are kept open until closed.
+func (c *fileHandleCache) invalidate(path string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, h := range c.entries {
+		if key.path == path || path == "." || strings.HasPrefix(key.path, path+"/") {
+			c.remove(h)
+		}
+	}
+}
+
+// open returns handle id for the file.
+func (c *fileHandleCache) open(key fileHandleKey, open func() (io.Closer, error)) (int64, error) {
+	c.lock.Lock()
+	n := len(c.handles)
+	c.lock.Unlock()
+	if n >= maxOpenHandles {
+		return 0, ErrTooManyHandles
+	}
+	h, err := c.acquire(key, open)
+	if err != nil {
+		return 0, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lastID++
+	c.handles[c.lastID] = h
+	return c.lastID, nil
+}
+
+func (c *fileHandleCache) get(id int64, write bool) (*fileHandle, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	h, ok := c.handles[id]
+	if !ok || h.key.write != write {
+		return nil, ErrInvalidHandle
+	}
+	h.refs++
+	h.used = time.Now()
+	return h, nil
+}
+
+func (c *fileHandleCache) close(id int64) error {
+	c.lock.Lock()
+	h, ok := c.handles[id]
+	delete(c.handles, id)
+	c.lock.Unlock()
+	if !ok {
+		return ErrInvalidHandle
+	}
+	c.release(h)
+	return nil
+}
+
+func (c *fileHandleCache) closeAll() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, h := range c.handles {
+		c.unref(h)
+	}
+	c.handles = map[int64]*fileHandle{}
+	for _, h := range c.entries {
+		c.remove(h)
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/semaphore"
@@ -26,8 +28,12 @@ type FileOperationRequest struct {
 	Pos   int64  `json:"p,omitempty"`
 	Len   int    `json:"l,omitempty"`
 	Buf   []byte `json:"b,omitempty"`
+	// Handle returned by "open" op
+	Handle int64 `json:"h,omitempty"`
 
 	Options map[string]string `json:"options,omitempty"`
+
+	binary bool
 }
 
 type FileOperationResult struct {
@@ -44,6 +50,18 @@ type FileEntry struct {
 	UpdatedTime int64  `json:"updatedTime,omitempty"`
 	CreatedTime int64  `json:"createdTime,omitempty"`
 	Writable    bool   `json:"writable,omitempty"`
+	// Full path of the entry. (search op only)
+	Path string `json:"path,omitempty"`
+
+	// Extended stat (optional)
+	FileMode     uint32 `json:"mode,omitempty"` // st_mode
+	LinkTarget   string `json:"linkTarget,omitempty"`
+	Inode        uint64 `json:"ino,omitempty"`
+	Nlink        uint64 `json:"nlink,omitempty"`
+	Uid          int    `json:"uid,omitempty"`
+	Gid          int    `json:"gid,omitempty"`
+	AccessedTime int64  `json:"accessedTime,omitempty"`
+	ChangedTime  int64  `json:"changedTime,omitempty"`
 
 	Metadata map[string]any `json:"metadata,omitempty"`
 }
@@ -61,6 +79,13 @@ func (f *FileEntry) IsDir() bool {
 }
 
 func (f *FileEntry) Mode() fs.FileMode {
+	if f.FileMode != 0 {
+		mode := fileModeFromUnix(f.FileMode)
+		if !f.Writable && mode&fs.ModeSymlink == 0 {
+			mode &^= 0222
+		}
+		return mode
+	}
 	var mode fs.FileMode = 1 << 8 // readable
 	if f.Writable {
 		mode |= 1 << 7
@@ -87,9 +112,55 @@ func (f *FileEntry) SetMetaData(key string, value any) {
 }
 
 const BinaryMessageResponseType = 0
+const BinaryMessageRequestType = 1
 const ThumbnailSuffix = "#thumbnail.jpeg"
 
+// Binary request header (little endian):
+//
+//	0: uint32 BinaryMessageRequestType
+//	4: uint32 rid
+//	8: int64  position
+//	16: uint32 length
+//	20: uint16 op length
+//	22: uint16 path length
+//	24: op, path, payload
+const binaryRequestHeaderSize = 24
+
+func ridToUint32(rid any) uint32 {
+	switch v := rid.(type) {
+	case uint32:
+		return v
+	case float64:
+		return uint32(v)
+	case int:
+		return uint32(v)
+	}
+	return 0
+}
+
+// SetBinary enables binary framing. Path2 and Options are not sent in binary requests.
+func (r *FileOperationRequest) SetBinary(binary bool) {
+	r.binary = binary
+}
+
+func (r *FileOperationRequest) IsJSON() bool {
+	return !r.binary
+}
+
 func (r *FileOperationRequest) ToBytes() []byte {
+	if !r.IsJSON() {
+		b := make([]byte, 0, binaryRequestHeaderSize+len(r.Op)+len(r.Path)+len(r.Buf))
+		b = binary.LittleEndian.AppendUint32(b, uint32(BinaryMessageRequestType))
+		b = binary.LittleEndian.AppendUint32(b, ridToUint32(r.RID))
+		b = binary.LittleEndian.AppendUint64(b, uint64(r.Pos))
+		b = binary.LittleEndian.AppendUint32(b, uint32(r.Len))
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(r.Op)))
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(r.Path)))
+		b = append(b, r.Op...)
+		b = append(b, r.Path...)
+		b = append(b, r.Buf...)
+		return b
+	}
 	b, err := json.Marshal(r)
 	if err != nil {
 		panic(err) // bug
@@ -97,6 +168,27 @@ func (r *FileOperationRequest) ToBytes() []byte {
 	return b
 }
 
+func ParseBinaryRequest(data []byte) (*FileOperationRequest, error) {
+	if len(data) < binaryRequestHeaderSize || binary.LittleEndian.Uint32(data) != BinaryMessageRequestType {
+		return nil, errors.New("invalid binary message")
+	}
+	opLen := int(binary.LittleEndian.Uint16(data[20:]))
+	pathLen := int(binary.LittleEndian.Uint16(data[22:]))
+	if len(data) < binaryRequestHeaderSize+opLen+pathLen {
+		return nil, errors.New("invalid binary message")
+	}
+	p := binaryRequestHeaderSize
+	return &FileOperationRequest{
+		binary: true,
+		RID:    float64(binary.LittleEndian.Uint32(data[4:])),
+		Pos:    int64(binary.LittleEndian.Uint64(data[8:])),
+		Len:    int(binary.LittleEndian.Uint32(data[16:])),
+		Op:     string(data[p : p+opLen]),
+		Path:   string(data[p+opLen : p+opLen+pathLen]),
+		Buf:    data[p+opLen+pathLen:],
+	}, nil
+}
+
 func (r *FileOperationResult) IsJSON() bool {
 	return r.Buf == nil
 }
@@ -105,7 +197,7 @@ func (r *FileOperationResult) ToBytes() []byte {
 	if !r.IsJSON() {
 		var b []byte
 		b = binary.LittleEndian.AppendUint32(b, uint32(BinaryMessageResponseType))
-		b = binary.LittleEndian.AppendUint32(b, uint32(r.RID.(float64))) // TODO
+		b = binary.LittleEndian.AppendUint32(b, ridToUint32(r.RID))
 		b = append(b, r.Buf...)
 		return b
 	}
@@ -117,16 +209,55 @@ func (r *FileOperationResult) ToBytes() []byte {
 }
 
 type FSServer struct {
-	fsys *WrappedFS
-	sem  *semaphore.Weighted
+	fsys  *WrappedFS
+	sem   *semaphore.Weighted
+	files *fileHandleCache
+	dirs  *dirCursors
+	acl   atomic.Pointer[ACL]
+
+	streamsLock sync.Mutex
+	streams     map[any]*serverStream
 }
 
 func NewFSServer(fsys fs.FS, parallels int) *FSServer {
-	return &FSServer{fsys: WrapFS(fsys), sem: semaphore.NewWeighted(int64(parallels))}
+	return &FSServer{fsys: WrapFS(fsys), sem: semaphore.NewWeighted(int64(parallels)), files: newFileHandleCache(16), dirs: newDirCursors(), streams: map[any]*serverStream{}}
+}
+
+// SetACL restricts operations. nil means full access.
+func (s *FSServer) SetACL(acl *ACL) {
+	s.acl.Store(acl)
 }
 
 func (s *FSServer) FSCaps() *FSCapability {
-	return s.fsys.Capability()
+	caps := s.fsys.Capability()
+	if acl := s.acl.Load(); acl != nil && !acl.Writable {
+		caps.Write = false
+		caps.Create = false
+		caps.Remove = false
+		caps.Symlink = false
+		caps.Link = false
+	}
+	caps.BinaryRequest = true
+	caps.ReadStream = true
+	// FileHandle is not advertised. "open" and "close" ops work, but requests by path use the cached files too.
+	caps.DirCursor = true
+	caps.Search = true
+	caps.ExtendedStat = true
+	caps.Hash = supportedHashAlgorithms()
+	return caps
+}
+
+// Close closes all cached files and streams.
+func (s *FSServer) Close() error {
+	s.streamsLock.Lock()
+	for rid, st := range s.streams {
+		close(st.done)
+		delete(s.streams, rid)
+	}
+	s.streamsLock.Unlock()
+	s.files.closeAll()
+	s.dirs.closeAll()
+	return nil
 }
 
 // well known types
@@ -204,6 +335,10 @@ func errorToStr(err error) string {
 		return "permission error"
 	} else if errors.Is(err, fs.ErrInvalid) {
 		return "invalid argument"
+	} else if errors.Is(err, fs.ErrExist) {
+		return "exist"
+	} else if errors.Is(err, ErrTooManyStreams) {
+		return "too many streams"
 	}
 	return fmt.Sprint(err)
 }
@@ -211,7 +346,10 @@ func errorToStr(err error) string {
 func (h *FSServer) ErrorReply(ctx context.Context, data []byte, isjson bool, writer func(*FileOperationResult) error, msg string) error {
 	var rid any
 	if !isjson {
-		rid = binary.LittleEndian.Uint32(data[4:8])
+		if len(data) < 8 {
+			return errors.New("invalid binary message")
+		}
+		rid = float64(binary.LittleEndian.Uint32(data[4:8]))
 	} else {
 		var op FileOperationRequest
 		err := json.Unmarshal(data, &op)
@@ -224,21 +362,25 @@ func (h *FSServer) ErrorReply(ctx context.Context, data []byte, isjson bool, wri
 }
 
 func (h *FSServer) HandleMessage(ctx context.Context, data []byte, isjson bool, writer func(*FileOperationResult) error) error {
+	var op *FileOperationRequest
 	if !isjson {
-		h.ErrorReply(ctx, data, isjson, writer, "TODO: support binary message")
-		return errors.New("not implemented")
-	}
-
-	var op FileOperationRequest
-	err := json.Unmarshal(data, &op)
-	if err != nil {
+		var err error
+		op, err = ParseBinaryRequest(data)
+		if err != nil {
+			h.ErrorReply(ctx, data, isjson, writer, "invalid message")
+			return err
+		}
+	} else if err := json.Unmarshal(data, &op); err != nil {
 		return err
 	}
+	if h.handleStreamOp(ctx, op, writer) {
+		return nil
+	}
 	h.sem.Acquire(ctx, 1)
 	go func() {
 		defer h.sem.Release(1)
 
-		ret, err := h.HanldeFileOp(&op)
+		ret, err := h.HanldeFileOp(op)
 		if err != nil {
 			writer(&FileOperationResult{RID: op.RID, Error: errorToStr(err)})
 		} else {
@@ -256,13 +398,17 @@ func (h *FSServer) HandleMessage(ctx context.Context, data []byte, isjson bool,
 	return nil
 }
 
-func (h *FSServer) readThumbnail(srcPath string, pos int64, len int) ([]byte, error) {
+func (h *FSServer) openThumbnail(srcPath string) (*os.File, error) {
 	typ := mime.TypeByExtension(path.Ext(srcPath))
 	thumb, err := DefaultThumbnailer.GetThumbnail(context.TODO(), h.fsys, srcPath, typ, nil)
 	if err != nil {
 		return nil, err
 	}
-	f, err := os.Open(thumb.Path)
+	return os.Open(thumb.Path)
+}
+
+func (h *FSServer) readThumbnail(srcPath string, pos int64, len int) ([]byte, error) {
+	f, err := h.openThumbnail(srcPath)
 	if err != nil {
 		return nil, err
 	}
@@ -329,20 +475,67 @@ func readNum(s string) (int, int) {
 	return v, p
 }
 
+func (h *FSServer) openFile(op *FileOperationRequest, write bool) (*fileHandle, error) {
+	if write && !h.FSCaps().Write {
+		return nil, fs.ErrPermission
+	}
+	if op.Handle != 0 {
+		return h.files.get(op.Handle, write)
+	}
+	return h.files.acquire(fileHandleKey{path: fixPath(op.Path), write: write}, h.fileOpener(fixPath(op.Path), write))
+}
+
+// withFile calls f with the opened file. A cached file is reopened once if f fails. (e.g. stale NFS handles)
+func (h *FSServer) withFile(op *FileOperationRequest, write bool, f func(file io.Closer) (any, error)) (any, error) {
+	for retry := 0; ; retry++ {
+		fh, err := h.openFile(op, write)
+		if err != nil {
+			return nil, err
+		}
+		ret, err := f(fh.file)
+		h.files.release(fh)
+		if err == nil || op.Handle != 0 || retry > 0 || errors.Is(err, io.EOF) || errors.Is(err, ErrUnsupported) {
+			return ret, err
+		}
+		h.files.discard(fh)
+	}
+}
+
+func (h *FSServer) fileOpener(name string, write bool) func() (io.Closer, error) {
+	return func() (io.Closer, error) {
+		if write {
+			return h.fsys.OpenWriter(name, os.O_CREATE|os.O_WRONLY)
+		}
+		return h.fsys.Open(name)
+	}
+}
+
 func (h *FSServer) HanldeFileOp(op *FileOperationRequest) (any, error) {
+	acl := h.acl.Load()
+	if err := acl.check(op); err != nil {
+		return nil, err
+	}
 	switch op.Op {
 	case "stat":
 		stat, err := fs.Stat(h.fsys, fixPath(op.Path))
 		if err != nil {
 			return nil, err
 		}
-		return NewFileEntry(stat, h.fsys.Capability().Write), nil
+		return h.newFileEntry(op, fixPath(op.Path), stat, h.FSCaps().Write && acl.allowed(op.Path)), nil
 	case "files":
-		// TODO: OpenDir(), ReadDirN()
 		entries, err := fs.ReadDir(h.fsys, fixPath(op.Path))
 		if err != nil {
 			return nil, err
 		}
+		if acl != nil {
+			var visibleEntries []fs.DirEntry
+			for _, ent := range entries {
+				if acl.visible(path.Join(fixPath(op.Path), ent.Name())) {
+					visibleEntries = append(visibleEntries, ent)
+				}
+			}
+			entries = visibleEntries
+		}
 		files := []*FileEntry{}
 		if op.Pos >= int64(len(entries)) {
 			return files, nil
@@ -378,48 +571,95 @@ func (h *FSServer) HanldeFileOp(op *FileOperationRequest) (any, error) {
 		}
 		infos = infos[op.Pos:end]
 		for _, info := range infos {
-			files = append(files, NewFileEntry(info, h.fsys.Capability().Write))
+			name := path.Join(fixPath(op.Path), info.Name())
+			files = append(files, h.newFileEntry(op, name, info, h.FSCaps().Write && acl.allowed(name)))
 		}
 		return files, nil
 	case "read":
 		if strings.HasSuffix(op.Path, ThumbnailSuffix) {
 			return h.readThumbnail(fixPath(strings.TrimSuffix(op.Path, ThumbnailSuffix)), op.Pos, op.Len)
 		}
-		f, err := h.fsys.Open(fixPath(op.Path))
-		if err != nil {
-			return nil, err
-		}
-		defer f.Close()
-		buf := make([]byte, op.Len)
-		if f, ok := f.(io.ReaderAt); ok {
+		return h.withFile(op, false, func(file io.Closer) (any, error) {
+			f, ok := file.(io.ReaderAt)
+			if !ok {
+				return nil, ErrUnsupported
+			}
+			buf := make([]byte, op.Len)
 			n, err := f.ReadAt(buf, op.Pos)
 			if err != nil && (n == 0 || err != io.ErrUnexpectedEOF && err != io.EOF) {
 				return nil, err
 			}
 			return buf[:n], nil
-		}
+		})
 	case "write":
-		f, err := h.fsys.OpenWriter(fixPath(op.Path), os.O_CREATE|os.O_WRONLY)
-		if err != nil {
-			return nil, err
-		}
-		defer f.Close()
-		if f, ok := f.(io.WriterAt); ok {
-			_, err := f.WriteAt(op.Buf, op.Pos)
-			if err != nil {
-				return nil, err
+		return h.withFile(op, true, func(file io.Closer) (any, error) {
+			f, ok := file.(io.WriterAt)
+			if !ok {
+				return nil, ErrUnsupported
 			}
-			return nil, nil
-		}
+			_, err := f.WriteAt(op.Buf, op.Pos)
+			return nil, err
+		})
 	case "truncate":
 		return nil, h.fsys.Truncate(fixPath(op.Path), op.Pos)
 	case "mkdir":
 		return nil, h.fsys.Mkdir(fixPath(op.Path), fs.ModePerm)
 	case "rename":
+		h.files.invalidate(fixPath(op.Path))
+		h.files.invalidate(fixPath(op.Path2))
 		return nil, h.fsys.Rename(fixPath(op.Path), fixPath(op.Path2))
 	case "remove":
+		h.files.invalidate(fixPath(op.Path))
 		err := h.fsys.Remove(fixPath(op.Path))
 		return err == nil, err
+	case "open":
+		write := op.Options["mode"] == "w"
+		if write && !h.FSCaps().Write {
+			return nil, fs.ErrPermission
+		}
+		id, err := h.files.open(fileHandleKey{path: fixPath(op.Path), write: write}, h.fileOpener(fixPath(op.Path), write))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"handle": id}, nil
+	case "close":
+		return nil, h.files.close(op.Handle)
+	case "hash":
+		return h.hashFile(op)
+	case "opendir":
+		id, err := h.dirs.open(h.fsys, fixPath(op.Path))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"cursor": id}, nil
+	case "readdir":
+		return h.readDirCursor(op, acl)
+	case "closedir":
+		return nil, h.dirs.close(op.Handle)
+	case "search":
+		id, err := h.search(op)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"cursor": id}, nil
+	case "removeAll":
+		h.files.invalidate(fixPath(op.Path))
+		return nil, h.fsys.RemoveAll(fixPath(op.Path))
+	case "copy":
+		h.files.invalidate(fixPath(op.Path2))
+		return nil, h.fsys.Copy(fixPath(op.Path), fixPath(op.Path2), op.Options["overwrite"] == "true")
+	case "move":
+		h.files.invalidate(fixPath(op.Path))
+		h.files.invalidate(fixPath(op.Path2))
+		return nil, h.fsys.Move(fixPath(op.Path), fixPath(op.Path2), op.Options["overwrite"] == "true")
+	case "readlink":
+		return h.fsys.ReadLink(fixPath(op.Path))
+	case "symlink":
+		h.files.invalidate(fixPath(op.Path))
+		return nil, h.fsys.Symlink(op.Options["target"], fixPath(op.Path))
+	case "link":
+		h.files.invalidate(fixPath(op.Path2))
+		return nil, h.fsys.Link(fixPath(op.Path), fixPath(op.Path2))
 	}
-	return nil, errors.New("unsupported operation")
+	return nil, ErrUnsupported
 }
@@ -1,9 +1,12 @@
 package socfs
 
 import (
+	"errors"
+	"io"
 	"io/fs"
 	"os"
 	"testing"
+	"time"
 )
 
 const dir = "../testdata"
@@ -71,7 +74,7 @@ func TestFileHandler_remove(t *testing.T) {
 
 func TestFileHandler_readtthumb(t *testing.T) {
 	server := NewFSServer(os.DirFS(dir), 1)
-	DefaultThumbnailer.Thumbnailers = append(DefaultThumbnailer.Thumbnailers, NewImageThumbnailer("cache"))
+	useImageThumbnailer(t)
 	ret, err := server.HanldeFileOp(&FileOperationRequest{Op: "read", Path: "test.png" + ThumbnailSuffix, Pos: 10, Len: 10})
 	if err != nil {
 		t.Fatal(err)
@@ -82,3 +85,177 @@ func TestFileHandler_readtthumb(t *testing.T) {
 		t.Error("type error", ret)
 	}
 }
+
+func TestFileHandler_binaryRequest(t *testing.T) {
+	req := &FileOperationRequest{Op: "write", RID: uint32(123), Path: "test.txt", Pos: 10, Buf: []byte("Hello!")}
+	req.SetBinary(true)
+	parsed, err := ParseBinaryRequest(req.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Op != req.Op || parsed.RID != float64(123) || parsed.Path != req.Path || parsed.Pos != req.Pos || string(parsed.Buf) != string(req.Buf) {
+		t.Error("unexpected request: ", parsed)
+	}
+
+	_, err = ParseBinaryRequest([]byte{1, 0, 0, 0})
+	if err == nil {
+		t.Error("ParseBinaryRequest() should be failed")
+	}
+}
+
+func TestFileHandler_handle(t *testing.T) {
+	server := NewFSServer(os.DirFS(dir), 1)
+	defer server.Close()
+	ret, err := server.HanldeFileOp(&FileOperationRequest{Op: "open", Path: "/test.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := ret.(map[string]any)["handle"].(int64)
+
+	ret, err = server.HanldeFileOp(&FileOperationRequest{Op: "read", Handle: handle, Pos: 10, Len: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := ret.([]byte); !ok || len(data) != 10 {
+		t.Error("read error", ret)
+	}
+
+	_, err = server.HanldeFileOp(&FileOperationRequest{Op: "write", Handle: handle, Buf: []byte("test")})
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Error("write should be failed: ", err)
+	}
+
+	_, err = server.HanldeFileOp(&FileOperationRequest{Op: "close", Handle: handle})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.HanldeFileOp(&FileOperationRequest{Op: "read", Handle: handle, Pos: 10, Len: 10})
+	if err != ErrInvalidHandle {
+		t.Error("read should be failed: ", err)
+	}
+}
+
+type fakeFile struct {
+	closed bool
+}
+
+func (f *fakeFile) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestFileHandleCache(t *testing.T) {
+	cache := newFileHandleCache(1)
+	open := func(f *fakeFile) func() (io.Closer, error) {
+		return func() (io.Closer, error) { return f, nil }
+	}
+
+	f1 := &fakeFile{}
+	h1, _ := cache.acquire(fileHandleKey{path: "a"}, open(f1))
+	cache.release(h1)
+	h1, _ = cache.acquire(fileHandleKey{path: "a"}, open(&fakeFile{}))
+	if h1.file != f1 {
+		t.Error("cached file should be used")
+	}
+
+	// evicted but in use
+	f2 := &fakeFile{}
+	h2, _ := cache.acquire(fileHandleKey{path: "b"}, open(f2))
+	if f1.closed {
+		t.Error("f1 is in use")
+	}
+	cache.release(h1)
+	if !f1.closed {
+		t.Error("f1 should be closed")
+	}
+
+	cache.release(h2)
+	cache.invalidate("b")
+	if !f2.closed {
+		t.Error("f2 should be closed")
+	}
+}
+
+func TestFileHandler_replacedFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(tmpDir+"/a.txt", []byte("aaa"), 0644)
+	fileHandleExpireTime = 50 * time.Millisecond
+	defer func() { fileHandleExpireTime = time.Second * 10 }()
+	server := NewFSServer(NewWritableDirFS(tmpDir), 1)
+	defer server.Close()
+
+	ret, err := server.HanldeFileOp(&FileOperationRequest{Op: "read", Path: "a.txt", Len: 10})
+	if err != nil || string(ret.([]byte)) != "aaa" {
+		t.Fatal("read error: ", ret, err)
+	}
+	os.WriteFile(tmpDir+"/b.txt", []byte("bbb"), 0644)
+	os.Rename(tmpDir+"/b.txt", tmpDir+"/a.txt")
+	// closed by the timer even if no more requests
+	time.Sleep(200 * time.Millisecond)
+	server.files.lock.Lock()
+	n := len(server.files.entries)
+	server.files.lock.Unlock()
+	if n != 0 {
+		t.Error("idle files should be closed: ", n)
+	}
+	ret, err = server.HanldeFileOp(&FileOperationRequest{Op: "read", Path: "a.txt", Len: 10})
+	if err != nil || string(ret.([]byte)) != "bbb" {
+		t.Error("replaced file should be reopened: ", ret, err)
+	}
+
+	// renaming doesn't close explicitly opened handles
+	ret, err = server.HanldeFileOp(&FileOperationRequest{Op: "open", Path: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := ret.(map[string]any)["handle"].(int64)
+	if _, err := server.HanldeFileOp(&FileOperationRequest{Op: "rename", Path: "a.txt", Path2: "c.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	ret, err = server.HanldeFileOp(&FileOperationRequest{Op: "read", Handle: handle, Len: 10})
+	if err != nil || string(ret.([]byte)) != "bbb" {
+		t.Error("handle should be kept open: ", ret, err)
+	}
+	server.HanldeFileOp(&FileOperationRequest{Op: "close", Handle: handle})
+	os.WriteFile(tmpDir+"/a.txt", []byte("aaa"), 0644)
+
+	for i := 0; i < maxOpenHandles; i++ {
+		if _, err := server.HanldeFileOp(&FileOperationRequest{Op: "open", Path: "a.txt"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = server.HanldeFileOp(&FileOperationRequest{Op: "open", Path: "a.txt"})
+	if !errors.Is(err, ErrTooManyHandles) {
+		t.Error("open should be failed: ", err)
+	}
+}
+
+func TestDirCursors_inUse(t *testing.T) {
+	dirs := newDirCursors()
+	defer dirs.closeAll()
+	fsys := os.DirFS(t.TempDir())
+
+	id, err := dirs.open(fsys, ".")
+	if err != nil {
+		t.Fatal("open() error: ", err)
+	}
+	d, err := dirs.get(id)
+	if err != nil {
+		t.Fatal("get() error: ", err)
+	}
+	for i := 0; i < maxDirCursors; i++ {
+		dirs.open(fsys, ".")
+	}
+	if _, err := dirs.get(id); err != nil {
+		t.Error("cursor in use should not be closed: ", err)
+	}
+	dirs.release(d)
+	dirs.release(d)
+	for i := 0; i < maxDirCursors; i++ {
+		dirs.open(fsys, ".")
+	}
+	if _, err := dirs.get(id); !errors.Is(err, ErrInvalidHandle) {
+		t.Error("least recently used cursor should be closed: ", err)
+	}
+}
@@ -0,0 +1,101 @@
+package socfs
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// hash op:
+//
+//	request: {op: "hash", path, p: position, options: {algorithm: "sha256", size}}
+//	response: {algorithm, hash: hex string, size: hashed bytes}
+const DefaultHashAlgorithm = "sha256"
+
+var ErrUnsupported = errors.New("unsupported operation")
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+type HashResult struct {
+	Algorithm string `json:"algorithm"`
+	Hash      string `json:"hash"`
+	Size      int64  `json:"size"`
+}
+
+func supportedHashAlgorithms() []string {
+	var algorithms []string
+	for name := range hashAlgorithms {
+		algorithms = append(algorithms, name)
+	}
+	sort.Strings(algorithms)
+	return algorithms
+}
+
+func (h *FSServer) hashFile(op *FileOperationRequest) (*HashResult, error) {
+	algorithm := op.Options["algorithm"]
+	if algorithm == "" {
+		algorithm = DefaultHashAlgorithm
+	}
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	f, err := h.fsys.Open(fixPath(op.Path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if op.Pos > 0 {
+		ra, ok := f.(io.ReaderAt)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		r = io.NewSectionReader(ra, op.Pos, 1<<62)
+	}
+	if size, err := strconv.ParseInt(op.Options["size"], 10, 64); err == nil && size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	hh := newHash()
+	n, err := io.Copy(hh, r)
+	if err != nil {
+		return nil, err
+	}
+	return &HashResult{Algorithm: algorithm, Hash: hex.EncodeToString(hh.Sum(nil)), Size: n}, nil
+}
+
+// Hash returns the hash of the file range. size < 0 means until EOF.
+func (c *FSClient) Hash(name, algorithm string, pos, size int64) (*HashResult, error) {
+	if algorithm == "" {
+		algorithm = DefaultHashAlgorithm
+	}
+	supported := false
+	for _, a := range c.capability().Hash {
+		supported = supported || a == algorithm
+	}
+	if !supported {
+		return nil, ErrUnsupported
+	}
+	req := &FileOperationRequest{Op: "hash", Path: name, Pos: pos, Options: map[string]string{"algorithm": algorithm}}
+	if size >= 0 {
+		req.Options["size"] = strconv.FormatInt(size, 10)
+	}
+	res, err := c.request(req)
+	if err != nil {
+		return nil, err
+	}
+	var result HashResult
+	return &result, json.Unmarshal(res.Data, &result)
+}
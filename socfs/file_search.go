@@ -0,0 +1,189 @@
+package socfs
+
+import (
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Search op:
+//
+//	request: {op: "search", path, options: {pattern, name, type, minSize, maxSize, after, before}}
+//	response: {cursor: id}
+//
+// Matched entries are read by the "readdir" op with the cursor and the cursor is closed by the "closedir" op.
+// SearchOptions filters entries in the "search" op. Zero values match all entries.
+type SearchOptions struct {
+	// Glob pattern for the file name. Patterns containing "/" match the relative path.
+	Pattern string
+	// Substring of the file name (case insensitive)
+	Name string
+	// Prefix of the content type. e.g. "image/" or "directory"
+	Type    string
+	MinSize int64
+	MaxSize int64
+	After   time.Time
+	Before  time.Time
+}
+
+func (o *SearchOptions) toMap() map[string]string {
+	m := map[string]string{}
+	if o == nil {
+		return m
+	}
+	if o.Pattern != "" {
+		m["pattern"] = o.Pattern
+	}
+	if o.Name != "" {
+		m["name"] = o.Name
+	}
+	if o.Type != "" {
+		m["type"] = o.Type
+	}
+	if o.MinSize > 0 {
+		m["minSize"] = strconv.FormatInt(o.MinSize, 10)
+	}
+	if o.MaxSize > 0 {
+		m["maxSize"] = strconv.FormatInt(o.MaxSize, 10)
+	}
+	if !o.After.IsZero() {
+		m["after"] = strconv.FormatInt(o.After.UnixMilli(), 10)
+	}
+	if !o.Before.IsZero() {
+		m["before"] = strconv.FormatInt(o.Before.UnixMilli(), 10)
+	}
+	return m
+}
+
+func parseSearchOptions(m map[string]string) (*SearchOptions, error) {
+	o := &SearchOptions{Pattern: m["pattern"], Name: m["name"], Type: m["type"]}
+	if _, err := path.Match(o.Pattern, ""); err != nil {
+		return nil, fs.ErrInvalid
+	}
+	for k, v := range map[string]*int64{"minSize": &o.MinSize, "maxSize": &o.MaxSize} {
+		if m[k] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(m[k], 10, 64)
+		if err != nil {
+			return nil, fs.ErrInvalid
+		}
+		*v = n
+	}
+	for k, v := range map[string]*time.Time{"after": &o.After, "before": &o.Before} {
+		if m[k] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(m[k], 10, 64)
+		if err != nil {
+			return nil, fs.ErrInvalid
+		}
+		*v = time.UnixMilli(n)
+	}
+	return o, nil
+}
+
+func (o *SearchOptions) match(rel string, ent *FileEntry) bool {
+	if o.Pattern != "" {
+		target := ent.Name()
+		if strings.Contains(o.Pattern, "/") {
+			target = rel
+		}
+		if ok, _ := path.Match(o.Pattern, target); !ok {
+			return false
+		}
+	}
+	if o.Name != "" && !strings.Contains(strings.ToLower(ent.Name()), strings.ToLower(o.Name)) {
+		return false
+	}
+	if o.Type != "" && !strings.HasPrefix(ent.Type, o.Type) {
+		return false
+	}
+	if o.MinSize > 0 && ent.Size() < o.MinSize || o.MaxSize > 0 && ent.Size() > o.MaxSize {
+		return false
+	}
+	if !o.After.IsZero() && ent.ModTime().Before(o.After) || !o.Before.IsZero() && !ent.ModTime().Before(o.Before) {
+		return false
+	}
+	return true
+}
+
+type searchFrame struct {
+	dir     string
+	entries []fs.DirEntry // entries not visited yet
+}
+
+// searchWalk walks the subtree in the same order as fs.WalkDir and can be resumed.
+type searchWalk struct {
+	opt   *SearchOptions
+	root  string
+	path  string // root path in the request
+	stack []searchFrame
+}
+
+// search opens a cursor to walk the subtree.
+func (h *FSServer) search(op *FileOperationRequest) (int64, error) {
+	opt, err := parseSearchOptions(op.Options)
+	if err != nil {
+		return 0, err
+	}
+	root := fixPath(op.Path)
+	entries, err := fs.ReadDir(h.fsys, root)
+	if err != nil {
+		return 0, err
+	}
+	walk := &searchWalk{opt: opt, root: root, path: op.Path, stack: []searchFrame{{dir: root, entries: entries}}}
+	return h.dirs.add(&dirCursor{walk: walk, path: root}), nil
+}
+
+// readSearch returns at most n matched entries from the cursor.
+func (h *FSServer) readSearch(op *FileOperationRequest, acl *ACL, w *searchWalk, n int) []*FileEntry {
+	files := []*FileEntry{}
+	writable := h.FSCaps().Write
+	for len(files) < n && len(w.stack) > 0 {
+		top := &w.stack[len(w.stack)-1]
+		if len(top.entries) == 0 {
+			w.stack = w.stack[:len(w.stack)-1]
+			continue
+		}
+		d := top.entries[0]
+		top.entries = top.entries[1:]
+		p := path.Join(top.dir, d.Name())
+		if !acl.visible(p) {
+			continue
+		}
+		if d.IsDir() {
+			if entries, err := fs.ReadDir(h.fsys, p); err == nil { // ignore unreadable directories
+				w.stack = append(w.stack, searchFrame{dir: p, entries: entries})
+			}
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		ent := h.newFileEntry(op, p, info, writable && acl.allowed(p))
+		rel := strings.TrimPrefix(p, w.root+"/")
+		if w.root == "." {
+			rel = p
+		}
+		if w.opt.match(rel, ent) {
+			ent.Path = path.Join(w.path, rel)
+			files = append(files, ent)
+		}
+	}
+	return files
+}
+
+// Search returns a cursor to read entries under root which match the options. Path of the entries is set.
+func (c *FSClient) Search(root string, opt *SearchOptions) (fs.ReadDirFile, error) {
+	if !c.capability().Search {
+		return nil, &fs.PathError{Op: "search", Path: root, Err: ErrUnsupported}
+	}
+	cursor, err := c.openCursor(&FileOperationRequest{Op: "search", Path: root, Options: opt.toMap()})
+	if err != nil {
+		return nil, err
+	}
+	return &clientFile{c: c, name: root, dirCursor: cursor}, nil
+}
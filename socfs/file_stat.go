@@ -0,0 +1,107 @@
+package socfs
+
+import (
+	"io/fs"
+)
+
+// Unix file type bits of st_mode
+const (
+	unixModeFIFO   = 0o010000
+	unixModeChar   = 0o020000
+	unixModeDir    = 0o040000
+	unixModeBlock  = 0o060000
+	unixModeFile   = 0o100000
+	unixModeLink   = 0o120000
+	unixModeSocket = 0o140000
+	unixModeType   = 0o170000
+)
+
+// unixMode converts fs.FileMode to st_mode.
+func unixMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= 0o4000
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= 0o2000
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= 0o1000
+	}
+	switch m.Type() {
+	case fs.ModeDir:
+		mode |= unixModeDir
+	case fs.ModeSymlink:
+		mode |= unixModeLink
+	case fs.ModeNamedPipe:
+		mode |= unixModeFIFO
+	case fs.ModeSocket:
+		mode |= unixModeSocket
+	case fs.ModeDevice | fs.ModeCharDevice:
+		mode |= unixModeChar
+	case fs.ModeDevice:
+		mode |= unixModeBlock
+	default:
+		mode |= unixModeFile
+	}
+	return mode
+}
+
+// fileModeFromUnix converts st_mode to fs.FileMode.
+func fileModeFromUnix(mode uint32) fs.FileMode {
+	m := fs.FileMode(mode & 0o777)
+	if mode&0o4000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		m |= fs.ModeSticky
+	}
+	switch mode & unixModeType {
+	case unixModeDir:
+		m |= fs.ModeDir
+	case unixModeLink:
+		m |= fs.ModeSymlink
+	case unixModeFIFO:
+		m |= fs.ModeNamedPipe
+	case unixModeSocket:
+		m |= fs.ModeSocket
+	case unixModeChar:
+		m |= fs.ModeDevice | fs.ModeCharDevice
+	case unixModeBlock:
+		m |= fs.ModeDevice
+	}
+	return m
+}
+
+// newFileEntry returns FileEntry with the extended stat if op has "stat": "extended" option.
+// Symlinks to files are reported as the linked file without the extended stat. Links to directories are not followed to avoid loops.
+func (h *FSServer) newFileEntry(op *FileOperationRequest, name string, info fs.FileInfo, writable bool) *FileEntry {
+	extended := op.Options["stat"] == "extended"
+	if info.Mode()&fs.ModeSymlink != 0 && !extended {
+		if st, err := h.fsys.Stat(name); err == nil && !st.IsDir() {
+			info = st
+		}
+	}
+	ent := NewFileEntry(info, writable)
+	if !extended || ent.FileMode != 0 {
+		return ent
+	}
+	ext := *ent
+	ext.FileMode = unixMode(info.Mode())
+	setSysStat(&ext, info.Sys())
+	if info.Mode()&fs.ModeSymlink != 0 {
+		ext.LinkTarget, _ = h.fsys.ReadLink(name)
+	}
+	return &ext
+}
+
+// statOptions returns request options for stat, files, readdir and search ops.
+func (c *FSClient) statOptions() map[string]string {
+	if c.ExtendedStat && c.capability().ExtendedStat {
+		return map[string]string{"stat": "extended"}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build darwin || freebsd || netbsd
+
+package socfs
+
+import (
+	"syscall"
+	"time"
+)
+
+func setSysStat(f *FileEntry, sys any) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	f.Inode = uint64(st.Ino)
+	f.Nlink = uint64(st.Nlink)
+	f.Uid = int(st.Uid)
+	f.Gid = int(st.Gid)
+	f.AccessedTime = time.Unix(st.Atimespec.Unix()).UnixMilli()
+	f.ChangedTime = time.Unix(st.Ctimespec.Unix()).UnixMilli()
+	f.CreatedTime = time.Unix(st.Birthtimespec.Unix()).UnixMilli()
+}
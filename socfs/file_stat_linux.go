@@ -0,0 +1,19 @@
+package socfs
+
+import (
+	"syscall"
+	"time"
+)
+
+func setSysStat(f *FileEntry, sys any) {
+	st, ok := sys.(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	f.Inode = st.Ino
+	f.Nlink = uint64(st.Nlink)
+	f.Uid = int(st.Uid)
+	f.Gid = int(st.Gid)
+	f.AccessedTime = time.Unix(st.Atim.Unix()).UnixMilli()
+	f.ChangedTime = time.Unix(st.Ctim.Unix()).UnixMilli()
+}
@@ -0,0 +1,6 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package socfs
+
+func setSysStat(f *FileEntry, sys any) {
+}
@@ -0,0 +1,15 @@
+package socfs
+
+import (
+	"syscall"
+	"time"
+)
+
+func setSysStat(f *FileEntry, sys any) {
+	st, ok := sys.(*syscall.Win32FileAttributeData)
+	if !ok {
+		return
+	}
+	f.AccessedTime = time.Unix(0, st.LastAccessTime.Nanoseconds()).UnixMilli()
+	f.CreatedTime = time.Unix(0, st.CreationTime.Nanoseconds()).UnixMilli()
+}
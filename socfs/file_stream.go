@@ -0,0 +1,250 @@
+package socfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// readstream op:
+//
+//	request: {op: "readstream", rid, path, p: position, l: chunk size, options: {size, window}}
+//	response: binary chunks with the same rid. empty chunk means EOF.
+//	client sends {op: "streamack", rid, l: chunks} for consumed chunks and {op: "streamclose", rid} to cancel.
+const DefaultStreamWindow = 8
+const MaxStreamChunkSize = 65000
+
+// Max concurrent streams per server. Clients should fall back to read op.
+const MaxStreams = 16
+
+var ErrTooManyStreams = errors.New("too many streams")
+
+type serverStream struct {
+	acked  atomic.Int64
+	notify chan struct{}
+	done   chan struct{}
+}
+
+func (s *serverStream) ack(n int) {
+	s.acked.Add(int64(n))
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (h *FSServer) handleStreamOp(ctx context.Context, op *FileOperationRequest, writer func(*FileOperationResult) error) bool {
+	switch op.Op {
+	case "readstream":
+		if err := h.acl.Load().check(op); err != nil {
+			writer(&FileOperationResult{RID: op.RID, Error: errorToStr(err)})
+			return true
+		}
+		s := &serverStream{notify: make(chan struct{}, 1), done: make(chan struct{})}
+		h.streamsLock.Lock()
+		if old, ok := h.streams[op.RID]; ok {
+			close(old.done)
+			delete(h.streams, op.RID)
+		}
+		if len(h.streams) >= MaxStreams {
+			h.streamsLock.Unlock()
+			writer(&FileOperationResult{RID: op.RID, Error: errorToStr(ErrTooManyStreams)})
+			return true
+		}
+		h.streams[op.RID] = s
+		h.streamsLock.Unlock()
+		go func() {
+			err := h.readStream(ctx, s, op, writer)
+			if err != nil {
+				writer(&FileOperationResult{RID: op.RID, Error: errorToStr(err)})
+			}
+			h.streamsLock.Lock()
+			if h.streams[op.RID] == s {
+				delete(h.streams, op.RID)
+			}
+			h.streamsLock.Unlock()
+		}()
+	case "streamack":
+		h.streamsLock.Lock()
+		s := h.streams[op.RID]
+		h.streamsLock.Unlock()
+		if s != nil {
+			s.ack(op.Len)
+		}
+	case "streamclose":
+		h.streamsLock.Lock()
+		if s, ok := h.streams[op.RID]; ok {
+			close(s.done)
+			delete(h.streams, op.RID)
+		}
+		h.streamsLock.Unlock()
+	default:
+		return false
+	}
+	return true
+}
+
+func (h *FSServer) readStream(ctx context.Context, s *serverStream, op *FileOperationRequest, writer func(*FileOperationResult) error) error {
+	chunkSize := op.Len
+	if chunkSize <= 0 || chunkSize > MaxStreamChunkSize {
+		chunkSize = MaxStreamChunkSize
+	}
+	window := DefaultStreamWindow
+	if w, err := strconv.Atoi(op.Options["window"]); err == nil && w > 0 {
+		window = w
+	}
+	end := int64(-1)
+	if sz, err := strconv.ParseInt(op.Options["size"], 10, 64); err == nil && sz >= 0 {
+		end = op.Pos + sz
+	}
+
+	var f fs.File
+	var err error
+	if strings.HasSuffix(op.Path, ThumbnailSuffix) {
+		f, err = h.openThumbnail(fixPath(strings.TrimSuffix(op.Path, ThumbnailSuffix)))
+	} else {
+		f, err = h.fsys.Open(fixPath(op.Path))
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, ok := f.(io.ReaderAt)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	pos := op.Pos
+	var sent int64
+	for {
+		for sent-s.acked.Load() >= int64(window) {
+			select {
+			case <-s.notify:
+			case <-s.done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		select {
+		case <-s.done:
+			return nil
+		default:
+		}
+		sz := int64(chunkSize)
+		if end >= 0 && end-pos < sz {
+			sz = end - pos
+		}
+		buf := make([]byte, sz)
+		n, err := r.ReadAt(buf, pos)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n > 0 {
+			if err := writer(&FileOperationResult{RID: op.RID, Buf: buf[:n]}); err != nil {
+				return err
+			}
+			sent++
+			pos += int64(n)
+		}
+		if n < len(buf) || n == 0 {
+			return writer(&FileOperationResult{RID: op.RID, Buf: []byte{}})
+		}
+	}
+}
+
+type clientReadStream struct {
+	c    *FSClient
+	req  *FileOperationRequest
+	rid  uint32
+	ch   chan *FileOperationResult
+	buf  []byte
+	pos  int64
+	done bool
+}
+
+// OpenReadStream starts streaming from the server. size < 0 means until EOF.
+func (c *FSClient) OpenReadStream(name string, pos, size int64) (io.ReadCloser, error) {
+	return c.openReadStream(name, pos, size)
+}
+
+func (c *FSClient) openReadStream(name string, pos, size int64) (*clientReadStream, error) {
+	window := c.StreamWindow
+	if window <= 0 {
+		window = DefaultStreamWindow
+	}
+	req := &FileOperationRequest{Op: "readstream", Path: name, Pos: pos, Len: c.MaxReadSize,
+		Options: map[string]string{"window": strconv.Itoa(window)}}
+	if size >= 0 {
+		req.Options["size"] = strconv.FormatInt(size, 10)
+	}
+	s := &clientReadStream{c: c, req: req, ch: make(chan *FileOperationResult, window+1), pos: pos}
+
+	c.locker.Lock()
+	c.reqCount++
+	s.rid = c.reqCount
+	c.streams[s.rid] = s.ch
+	c.locker.Unlock()
+
+	req.RID = s.rid
+	if err := c.sendNoReply(req); err != nil {
+		c.closeStream(s.rid)
+		return nil, &connectionError{err}
+	}
+	return s, nil
+}
+
+func (c *FSClient) closeStream(rid uint32) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	delete(c.streams, rid)
+}
+
+func (s *clientReadStream) Read(b []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		var res *FileOperationResult
+		select {
+		case <-time.After(s.c.Timeout):
+			s.Close()
+			return 0, errTimeout
+		case res = <-s.ch:
+			if res == nil {
+				s.done = true
+				return 0, errDisconnected
+			}
+		}
+		if err := resultError(s.req, res); err != nil {
+			s.done = true
+			s.c.closeStream(s.rid)
+			return 0, err
+		}
+		if len(res.Buf) == 0 {
+			s.done = true
+			s.c.closeStream(s.rid)
+			return 0, io.EOF
+		}
+		s.buf = res.Buf
+		_ = s.c.sendNoReply(&FileOperationRequest{Op: "streamack", RID: s.rid, Len: 1})
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	s.pos += int64(n)
+	return n, nil
+}
+
+func (s *clientReadStream) Close() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	s.c.closeStream(s.rid)
+	return s.c.sendNoReply(&FileOperationRequest{Op: "streamclose", RID: s.rid})
+}
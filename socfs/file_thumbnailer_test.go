@@ -7,8 +7,8 @@ import (
 )
 
 func TestGetThumbnail(t *testing.T) {
-	thumbnailer := NewImageThumbnailer("cache")
-	thumb, err := thumbnailer.GetThumbnail(context.Background(), os.DirFS("testdata"), "test.png", "image/png", nil)
+	thumbnailer := NewImageThumbnailer(t.TempDir())
+	thumb, err := thumbnailer.GetThumbnail(context.Background(), os.DirFS(dir), "test.png", "image/png", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -16,3 +16,10 @@ func TestGetThumbnail(t *testing.T) {
 		t.Fatal("thumb should not be null")
 	}
 }
+
+// useImageThumbnailer adds the image thumbnailer with a temporary cache directory until the test ends.
+func useImageThumbnailer(t *testing.T) {
+	thumbnailers := DefaultThumbnailer.Thumbnailers
+	t.Cleanup(func() { DefaultThumbnailer.Thumbnailers = thumbnailers })
+	DefaultThumbnailer.Thumbnailers = append(thumbnailers[:len(thumbnailers):len(thumbnailers)], NewImageThumbnailer(t.TempDir()))
+}
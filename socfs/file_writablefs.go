@@ -1,10 +1,13 @@
 package socfs
 
 import (
+	"errors"
 	"io"
 	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 )
 
 type FSCapability struct {
@@ -12,6 +15,17 @@ type FSCapability struct {
 	Write  bool `json:"write"`
 	Create bool `json:"create"`
 	Remove bool `json:"remove"`
+
+	BinaryRequest bool `json:"binaryRequest,omitempty"`
+	ReadStream    bool `json:"readStream,omitempty"`
+	FileHandle    bool `json:"fileHandle,omitempty"`
+	DirCursor     bool `json:"dirCursor,omitempty"`
+	Search        bool `json:"search,omitempty"`
+	ExtendedStat  bool `json:"extendedStat,omitempty"`
+	Symlink       bool `json:"symlink,omitempty"`
+	Link          bool `json:"link,omitempty"`
+
+	Hash []string `json:"hash,omitempty"`
 }
 
 type OpenWriterFS interface {
@@ -22,6 +36,10 @@ type RemoveFS interface {
 	Remove(name string) error
 }
 
+type RemoveAllFS interface {
+	RemoveAll(name string) error
+}
+
 type RenameFS interface {
 	Rename(name string, newName string) error
 }
@@ -30,6 +48,18 @@ type MkdirFS interface {
 	Mkdir(name string, mode fs.FileMode) error
 }
 
+type ReadLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+type SymlinkFS interface {
+	Symlink(target, name string) error
+}
+
+type LinkFS interface {
+	Link(name, newName string) error
+}
+
 type OpenDirFS interface {
 	OpenDir(name string) (fs.ReadDirFile, error)
 }
@@ -48,8 +78,12 @@ type WrappedFS struct {
 	createFS     CreateFS
 	truncateFS   TruncateFS
 	removeFS     RemoveFS
+	removeAllFS  RemoveAllFS
 	renameFS     RenameFS
 	mkdirFS      MkdirFS
+	readLinkFS   ReadLinkFS
+	symlinkFS    SymlinkFS
+	linkFS       LinkFS
 }
 
 func WrapFS(fsys fs.FS) *WrappedFS {
@@ -61,8 +95,12 @@ func WrapFS(fsys fs.FS) *WrappedFS {
 	w.createFS, _ = fsys.(CreateFS)
 	w.truncateFS, _ = fsys.(TruncateFS)
 	w.removeFS, _ = fsys.(RemoveFS)
+	w.removeAllFS, _ = fsys.(RemoveAllFS)
 	w.renameFS, _ = fsys.(RenameFS)
 	w.mkdirFS, _ = fsys.(MkdirFS)
+	w.readLinkFS, _ = fsys.(ReadLinkFS)
+	w.symlinkFS, _ = fsys.(SymlinkFS)
+	w.linkFS, _ = fsys.(LinkFS)
 	return w
 }
 
@@ -72,6 +110,9 @@ func (w *WrappedFS) Capability() *FSCapability {
 		Write:  w.openWriterFS != nil,
 		Create: w.createFS != nil || w.openWriterFS != nil,
 		Remove: w.removeFS != nil,
+
+		Symlink: w.symlinkFS != nil,
+		Link:    w.linkFS != nil,
 	}
 }
 
@@ -79,8 +120,11 @@ func (w *WrappedFS) ReadOnly() *WrappedFS {
 	w.openWriterFS = nil
 	w.createFS = nil
 	w.removeFS = nil
+	w.removeAllFS = nil
 	w.renameFS = nil
 	w.mkdirFS = nil
+	w.symlinkFS = nil
+	w.linkFS = nil
 	return w
 }
 
@@ -139,6 +183,46 @@ func (w *WrappedFS) Mkdir(path string, mode fs.FileMode) error {
 	return fs.ErrPermission
 }
 
+func (w *WrappedFS) ReadLink(name string) (string, error) {
+	if w.readLinkFS != nil {
+		return w.readLinkFS.ReadLink(name)
+	}
+	return "", ErrUnsupported
+}
+
+// symlinkTarget returns the path of the symlink target. ok is false if the target is absolute or contains "..".
+// ".." is resolved from the real directory of the link, so it can't be checked by the path of the link.
+func symlinkTarget(name, target string) (string, bool) {
+	slashTarget := filepath.ToSlash(target)
+	if target == "" || filepath.IsAbs(target) || path.IsAbs(slashTarget) || filepath.VolumeName(target) != "" {
+		return "", false
+	}
+	for _, s := range strings.Split(slashTarget, "/") {
+		if s == ".." {
+			return "", false
+		}
+	}
+	p := path.Join(path.Dir(fixPath(name)), slashTarget)
+	return p, fs.ValidPath(p)
+}
+
+func (w *WrappedFS) Symlink(target, name string) error {
+	if _, ok := symlinkTarget(name, target); !ok {
+		return &fs.PathError{Op: "symlink", Path: name, Err: fs.ErrPermission}
+	}
+	if w.symlinkFS != nil {
+		return w.symlinkFS.Symlink(target, name)
+	}
+	return fs.ErrPermission
+}
+
+func (w *WrappedFS) Link(name, newName string) error {
+	if w.linkFS != nil {
+		return w.linkFS.Link(name, newName)
+	}
+	return fs.ErrPermission
+}
+
 func (w *WrappedFS) Stat(name string) (fs.FileInfo, error) {
 	return fs.Stat(w.FS, name)
 }
@@ -149,18 +233,174 @@ func (w *WrappedFS) ReadDir(name string) ([]fs.DirEntry, error) {
 
 type writableDirFS struct {
 	fs.StatFS
-	path string
+	path             string
+	restrictSymlinks bool
 }
 
 func NewWritableDirFS(path string) *writableDirFS {
 	return &writableDirFS{StatFS: os.DirFS(path).(fs.StatFS), path: path}
 }
 
-func (fsys *writableDirFS) OpenWriter(name string, flag int) (io.WriteCloser, error) {
+// RestrictSymlinks refuses to follow symlinks which resolve outside the root.
+func (fsys *writableDirFS) RestrictSymlinks() *writableDirFS {
+	fsys.restrictSymlinks = true
+	return fsys
+}
+
+// resolve returns the local path of name. If followLast is false, only the parent directory is checked.
+func (fsys *writableDirFS) resolve(op, name string, followLast bool) (string, error) {
+	localPath, _, err := fsys.realPath(op, name, followLast)
+	return localPath, err
+}
+
+// realPath returns the local path and the path without symlinks of name.
+func (fsys *writableDirFS) realPath(op, name string, followLast bool) (string, string, error) {
 	if !fs.ValidPath(name) {
-		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+		return "", "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	localPath := filepath.Join(fsys.path, filepath.FromSlash(name))
+	if !fsys.restrictSymlinks {
+		return localPath, localPath, nil
+	}
+	root, err := filepath.Abs(fsys.path)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	localPath, err = filepath.Abs(localPath)
+	if err != nil {
+		return "", "", err
+	}
+	var realPath string
+	if followLast {
+		realPath, err = filepath.EvalSymlinks(localPath)
+		if st, lerr := os.Lstat(localPath); errors.Is(err, fs.ErrNotExist) && lerr == nil && st.Mode()&fs.ModeSymlink != 0 {
+			// dangling symlink
+			return "", "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+		}
+	}
+	if !followLast || errors.Is(err, fs.ErrNotExist) {
+		var parent string
+		parent, err = filepath.EvalSymlinks(filepath.Dir(localPath))
+		realPath = filepath.Join(parent, filepath.Base(localPath))
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if rel, err := filepath.Rel(root, realPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return localPath, realPath, nil
+}
+
+// verify checks that info is the file which name resolves to now.
+// A path component may be replaced with a symlink after resolve() and before the file is opened.
+func (fsys *writableDirFS) verify(op, name string, info fs.FileInfo, err error) error {
+	if err != nil || !fsys.restrictSymlinks {
+		return err
+	}
+	_, realPath, err := fsys.realPath(op, name, true)
+	if err != nil {
+		return err
+	}
+	if realInfo, err := os.Lstat(realPath); err != nil || !os.SameFile(info, realInfo) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
 	}
-	return os.OpenFile(path.Join(fsys.path, name), flag, fs.ModePerm)
+	return nil
+}
+
+// checkNoSymlinks returns an error if the path of the directory contains symlinks.
+func (fsys *writableDirFS) checkNoSymlinks(op, dir string) error {
+	root, err := filepath.EvalSymlinks(fsys.path)
+	if err != nil {
+		return err
+	}
+	realDir, err := filepath.EvalSymlinks(filepath.Join(fsys.path, filepath.FromSlash(dir)))
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(root, realDir); err != nil || filepath.ToSlash(rel) != dir {
+		return &fs.PathError{Op: op, Path: dir, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+// openParent opens the parent directory of name. Ops relative to the directory are not affected by replaced path components.
+func (fsys *writableDirFS) openParent(op, name string) (*os.File, string, error) {
+	p, err := fsys.resolve(op, name, false)
+	if err != nil {
+		return nil, "", err
+	}
+	dir, err := os.Open(filepath.Dir(p))
+	if err != nil {
+		return nil, "", err
+	}
+	info, err := dir.Stat()
+	if err := fsys.verify(op, path.Dir(name), info, err); err != nil {
+		dir.Close()
+		return nil, "", err
+	}
+	return dir, filepath.Base(p), nil
+}
+
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (fsys *writableDirFS) Open(name string) (fs.File, error) {
+	if _, err := fsys.resolve("open", name, true); err != nil {
+		return nil, err
+	}
+	f, err := fsys.StatFS.Open(name)
+	if err != nil || !fsys.restrictSymlinks {
+		return f, err
+	}
+	info, err := f.Stat()
+	if err := fsys.verify("open", name, info, err); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fsys *writableDirFS) Stat(name string) (fs.FileInfo, error) {
+	if _, err := fsys.resolve("stat", name, true); err != nil {
+		return nil, err
+	}
+	info, err := fsys.StatFS.Stat(name)
+	if err := fsys.verify("stat", name, info, err); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// OpenWriter opens the file for writing. O_TRUNC is applied after verify() so that files outside the root are never truncated.
+func (fsys *writableDirFS) OpenWriter(name string, flag int) (io.WriteCloser, error) {
+	p, err := fsys.resolve("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, flag&^os.O_TRUNC, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err := fsys.verify("open", name, info, err); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if flag&os.O_TRUNC != 0 {
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
 }
 
 func (fsys *writableDirFS) Create(name string) (io.WriteCloser, error) {
@@ -168,22 +408,78 @@ func (fsys *writableDirFS) Create(name string) (io.WriteCloser, error) {
 }
 
 func (fsys *writableDirFS) Remove(name string) error {
-	if !fs.ValidPath(name) {
-		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	dir, base, err := fsys.openParent("remove", name)
+	if err != nil {
+		return err
 	}
-	return os.Remove(path.Join(fsys.path, name))
+	defer dir.Close()
+	return pathError("remove", name, removeAt(dir, base))
 }
 
 func (fsys *writableDirFS) Rename(name, newName string) error {
-	if !fs.ValidPath(name) || !fs.ValidPath(newName) {
-		return &fs.PathError{Op: "rename", Path: name, Err: fs.ErrInvalid}
+	dir, base, err := fsys.openParent("rename", name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	newDir, newBase, err := fsys.openParent("rename", newName)
+	if err != nil {
+		return err
 	}
-	return os.Rename(path.Join(fsys.path, name), path.Join(fsys.path, newName))
+	defer newDir.Close()
+	return pathError("rename", name, renameAt(dir, base, newDir, newBase))
 }
 
 func (fsys *writableDirFS) Mkdir(name string, mode fs.FileMode) error {
-	if !fs.ValidPath(name) {
-		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	dir, base, err := fsys.openParent("mkdir", name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return pathError("mkdir", name, mkdirAt(dir, base, mode))
+}
+
+func (fsys *writableDirFS) ReadLink(name string) (string, error) {
+	dir, base, err := fsys.openParent("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	defer dir.Close()
+	target, err := readLinkAt(dir, base)
+	return target, pathError("readlink", name, err)
+}
+
+// Symlink creates a symlink. The target must be a relative path inside the root.
+// Links can't be created through existing symlinks because the target is relative to the real directory.
+func (fsys *writableDirFS) Symlink(target, name string) error {
+	p, ok := symlinkTarget(name, target)
+	if !ok {
+		return &fs.PathError{Op: "symlink", Path: name, Err: fs.ErrPermission}
+	}
+	if _, err := fsys.resolve("symlink", p, true); err != nil {
+		return err
+	}
+	if err := fsys.checkNoSymlinks("symlink", path.Dir(name)); err != nil {
+		return err
+	}
+	dir, base, err := fsys.openParent("symlink", name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return pathError("symlink", name, symlinkAt(target, dir, base))
+}
+
+func (fsys *writableDirFS) Link(name, newName string) error {
+	dir, base, err := fsys.openParent("link", name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	newDir, newBase, err := fsys.openParent("link", newName)
+	if err != nil {
+		return err
 	}
-	return os.Mkdir(path.Join(fsys.path, name), mode)
+	defer newDir.Close()
+	return pathError("link", name, linkAt(dir, base, newDir, newBase))
 }
@@ -0,0 +1,37 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package socfs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+func removeAt(dir *os.File, name string) error {
+	return os.Remove(filepath.Join(dir.Name(), name))
+}
+
+func removeAllAt(dir *os.File, name string) error {
+	return os.RemoveAll(filepath.Join(dir.Name(), name))
+}
+
+func renameAt(dir *os.File, name string, newDir *os.File, newName string) error {
+	return os.Rename(filepath.Join(dir.Name(), name), filepath.Join(newDir.Name(), newName))
+}
+
+func mkdirAt(dir *os.File, name string, mode fs.FileMode) error {
+	return os.Mkdir(filepath.Join(dir.Name(), name), mode)
+}
+
+func readLinkAt(dir *os.File, name string) (string, error) {
+	return os.Readlink(filepath.Join(dir.Name(), name))
+}
+
+func symlinkAt(target string, dir *os.File, name string) error {
+	return os.Symlink(target, filepath.Join(dir.Name(), name))
+}
+
+func linkAt(dir *os.File, name string, newDir *os.File, newName string) error {
+	return os.Link(filepath.Join(dir.Name(), name), filepath.Join(newDir.Name(), newName))
+}
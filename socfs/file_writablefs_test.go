@@ -0,0 +1,132 @@
+package socfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritableDirFS_RestrictSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0600)
+	os.Mkdir(filepath.Join(root, "dir"), 0700)
+	os.WriteFile(filepath.Join(root, "dir", "a.txt"), []byte("a"), 0600)
+	if err := os.Symlink(outside, filepath.Join(root, "outdir")); err != nil {
+		t.Skip("symlink not supported: ", err)
+	}
+	os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "outfile"))
+	os.Symlink(filepath.Join(outside, "new.txt"), filepath.Join(root, "dangling"))
+	os.Symlink(filepath.Join(root, "dir"), filepath.Join(root, "indir"))
+
+	fsys := NewWritableDirFS(root)
+	if _, err := fs.ReadFile(fsys, "outfile"); err != nil {
+		t.Error("symlinks should be followed by default: ", err)
+	}
+
+	fsys.RestrictSymlinks()
+	for _, name := range []string{"outfile", "outdir/secret.txt"} {
+		if _, err := fs.ReadFile(fsys, name); !errors.Is(err, fs.ErrPermission) {
+			t.Error("read should be permission error: ", name, err)
+		}
+	}
+	if _, err := fs.ReadDir(fsys, "outdir"); !errors.Is(err, fs.ErrPermission) {
+		t.Error("readdir should be permission error: ", err)
+	}
+	for _, name := range []string{"outfile", "outdir/new.txt", "dangling"} {
+		if _, err := fsys.OpenWriter(name, os.O_CREATE|os.O_WRONLY); !errors.Is(err, fs.ErrPermission) {
+			t.Error("write should be permission error: ", name, err)
+		}
+	}
+	if err := fsys.Rename("dir/a.txt", "outdir/a.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Error("rename should be permission error: ", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("file created outside the root")
+	}
+
+	if _, err := fs.ReadFile(fsys, "indir/a.txt"); err != nil {
+		t.Error("symlinks inside the root should be allowed: ", err)
+	}
+	w, err := fsys.OpenWriter("indir/b.txt", os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := fsys.Rename("indir/b.txt", "c.txt"); err != nil {
+		t.Error(err)
+	}
+	if err := fsys.Remove("outfile"); err != nil {
+		t.Error("symlink itself should be removable: ", err)
+	}
+}
+
+func TestWritableDirFS_replacedPath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "a.txt"), []byte("secret"), 0600)
+	os.Mkdir(filepath.Join(root, "dir"), 0700)
+	os.WriteFile(filepath.Join(root, "dir", "a.txt"), []byte("a"), 0600)
+	fsys := NewWritableDirFS(root).RestrictSymlinks()
+
+	// opened after "dir" was replaced with a symlink, and verified after it was restored.
+	f, err := os.Open(filepath.Join(outside, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err := fsys.verify("open", "dir/a.txt", info, err); !errors.Is(err, fs.ErrPermission) {
+		t.Error("verify() should be permission error: ", err)
+	}
+
+	dir, base, err := fsys.openParent("mkdir", "dir/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	os.Rename(filepath.Join(root, "dir"), filepath.Join(root, "dir2"))
+	if err := os.Symlink(outside, filepath.Join(root, "dir")); err != nil {
+		t.Skip("symlink not supported: ", err)
+	}
+	if err := mkdirAt(dir, base, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new")); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("directory created outside the root")
+	}
+	if _, err := os.Stat(filepath.Join(root, "dir2", "new")); err != nil {
+		t.Error("directory should be created in the opened directory: ", err)
+	}
+
+	os.Symlink(outside, filepath.Join(root, "dir2", "link"))
+	if err := fsys.RemoveAll("dir2"); err != nil {
+		t.Error("RemoveAll() error: ", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "a.txt")); err != nil {
+		t.Error("RemoveAll() should not follow symlinks: ", err)
+	}
+}
+
+func TestWrappedFS_RemoveAll(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "a", "b"), 0755)
+	os.WriteFile(filepath.Join(root, "a", "b", "c.txt"), []byte("c"), 0644)
+
+	// RemoveAll without RemoveAllFS
+	fsys := WrapFS(struct {
+		fs.StatFS
+		RemoveFS
+	}{NewWritableDirFS(root), NewWritableDirFS(root)})
+	if err := fsys.RemoveAll("a"); err != nil {
+		t.Fatal("RemoveAll() error: ", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "a")); !os.IsNotExist(err) {
+		t.Error("a should be removed: ", err)
+	}
+	if err := fsys.RemoveAll("a"); err != nil {
+		t.Error("RemoveAll() should ignore missing files: ", err)
+	}
+}
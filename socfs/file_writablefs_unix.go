@@ -0,0 +1,79 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package socfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func removeAt(dir *os.File, name string) error {
+	err := unix.Unlinkat(int(dir.Fd()), name, 0)
+	if err == nil {
+		return nil
+	}
+	err1 := unix.Unlinkat(int(dir.Fd()), name, unix.AT_REMOVEDIR)
+	if err1 == nil {
+		return nil
+	}
+	if err1 != unix.ENOTDIR {
+		err = err1
+	}
+	return err
+}
+
+// removeAllAt removes name in dir without following symlinks.
+func removeAllAt(dir *os.File, name string) error {
+	err := removeAt(dir, name)
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	fd, oerr := unix.Openat(int(dir.Fd()), name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if oerr != nil {
+		return err
+	}
+	sub := os.NewFile(uintptr(fd), name)
+	defer sub.Close()
+	names, err := sub.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if err := removeAllAt(sub, n); err != nil {
+			return err
+		}
+	}
+	return removeAt(dir, name)
+}
+
+func renameAt(dir *os.File, name string, newDir *os.File, newName string) error {
+	return unix.Renameat(int(dir.Fd()), name, int(newDir.Fd()), newName)
+}
+
+func mkdirAt(dir *os.File, name string, mode fs.FileMode) error {
+	return unix.Mkdirat(int(dir.Fd()), name, uint32(mode.Perm()))
+}
+
+func readLinkAt(dir *os.File, name string) (string, error) {
+	for n := 256; ; n *= 2 {
+		buf := make([]byte, n)
+		l, err := unix.Readlinkat(int(dir.Fd()), name, buf)
+		if err != nil {
+			return "", err
+		}
+		if l < n {
+			return string(buf[:l]), nil
+		}
+	}
+}
+
+func symlinkAt(target string, dir *os.File, name string) error {
+	return unix.Symlinkat(target, int(dir.Fd()), name)
+}
+
+func linkAt(dir *os.File, name string, newDir *os.File, newName string) error {
+	return unix.Linkat(int(dir.Fd()), name, int(newDir.Fd()), newName, 0)
+}